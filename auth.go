@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	authModeToken  = "token"
+	authModeGitHub = "github"
+	authModeGitea  = "gitea"
+	authModeGitLab = "gitlab"
+	authModeHMAC   = "hmac"
+)
+
+var (
+	authModes      = []string{authModeToken, authModeGitHub, authModeGitea, authModeGitLab, authModeHMAC}
+	hmacAlgorithms = map[string]func() hash.Hash{"sha1": sha1.New, "sha256": sha256.New, "sha512": sha512.New}
+	hmacEncodings  = []string{"hex", "base64"}
+)
+
+// authentication selects how callers of a script prove who they are. The default mode checks the
+// token in the Authorization header, the others verify the headers sent by forge webhooks.
+type authentication struct {
+	Mode      string `yaml:"mode"`
+	Secret    string `yaml:"secret,omitempty"`
+	Header    string `yaml:"header,omitempty"`
+	Algorithm string `yaml:"algorithm,omitempty"`
+	Prefix    string `yaml:"prefix,omitempty"`
+	Encoding  string `yaml:"encoding,omitempty"`
+}
+
+func (a authentication) isValid() bool {
+	if a.Mode == "" || a.Mode == authModeToken {
+		return true
+	}
+	if !slices.Contains(authModes, a.Mode) || a.Secret == "" {
+		return false
+	}
+	if a.Mode == authModeHMAC {
+		_, knownAlgorithm := hmacAlgorithms[a.getAlgorithm()]
+		return a.Header != "" && knownAlgorithm && slices.Contains(hmacEncodings, a.getEncoding())
+	}
+	return true
+}
+
+// signsBody tells if the mode verifies a signature of the request body
+func (a authentication) signsBody() bool {
+	return a.Mode == authModeGitHub || a.Mode == authModeGitea || a.Mode == authModeHMAC
+}
+
+func (a authentication) getAlgorithm() string {
+	if a.Algorithm == "" {
+		return "sha256"
+	}
+	return strings.ToLower(a.Algorithm)
+}
+
+func (a authentication) getEncoding() string {
+	if a.Encoding == "" {
+		return "hex"
+	}
+	return strings.ToLower(a.Encoding)
+}
+
+// signatureSettings returns the HMAC settings of the mode, using the presets of the forges
+func (a authentication) signatureSettings() authentication {
+	switch a.Mode {
+	case authModeGitHub, authModeGitea:
+		return authentication{Mode: authModeHMAC, Secret: a.Secret, Header: "X-Hub-Signature-256", Algorithm: "sha256", Prefix: "sha256=", Encoding: "hex"}
+	default:
+		return a
+	}
+}
+
+// credential is a token that grants access to scripts. Its name identifies who is calling in the logs.
+type credential struct {
+	Name      string    `yaml:"name"`
+	Token     string    `yaml:"token,omitempty"`
+	TokenHash string    `yaml:"token_hash,omitempty"`
+	ExpiresAt time.Time `yaml:"expires_at,omitempty"`
+	Disabled  bool      `yaml:"disabled"`
+	RateLimit rateLimit `yaml:"rate_limit,omitempty"`
+	// scope tells credentials with the same name apart: the ID of the script they belong to or "default"
+	scope string
+}
+
+func (cr credential) isValid() bool {
+	if cr.Name == "" || !cr.RateLimit.isValid() {
+		return false
+	}
+	if cr.TokenHash != "" {
+		return cr.Token == "" && isValidTokenHash(cr.TokenHash)
+	}
+	return cr.Token != ""
+}
+
+func (cr credential) isExpired() bool {
+	return !cr.ExpiresAt.IsZero() && time.Now().After(cr.ExpiresAt)
+}
+
+func (cr credential) matches(provided string) bool {
+	if cr.TokenHash != "" {
+		return verifyTokenHash(provided, cr.TokenHash)
+	}
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(cr.Token)) == 1
+}
+
+func areValidCredentials(credentials []credential) bool {
+	names := make(map[string]bool)
+	for _, cr := range credentials {
+		if !cr.isValid() || names[cr.Name] {
+			return false
+		}
+		names[cr.Name] = true
+	}
+	return true
+}
+
+// credentials returns the credentials accepted by the script: its own ones or, if it has none, the default ones.
+// The single token settings are kept as shorthands for a credential named after them.
+func (s script) credentials(c configuration) []credential {
+	var credentials []credential
+	if s.Token != "" || s.TokenHash != "" {
+		credentials = append(credentials, credential{Name: "token", Token: s.Token, TokenHash: s.TokenHash})
+	}
+	credentials = append(credentials, s.Tokens...)
+	if len(credentials) > 0 {
+		return withScope(credentials, s.ID.String())
+	}
+
+	if c.DefaultToken != "" || c.DefaultTokenHash != "" {
+		credentials = append(credentials, credential{Name: "default_token", Token: c.DefaultToken, TokenHash: c.DefaultTokenHash})
+	}
+	return withScope(append(credentials, c.DefaultTokens...), "default")
+}
+
+func withScope(credentials []credential, scope string) []credential {
+	for i := range credentials {
+		credentials[i].scope = scope
+	}
+	return credentials
+}
+
+// checkAuthorization verifies the request according to the script's authentication mode and returns
+// the credential that was used
+func checkAuthorization(r *http.Request, body []byte, scriptToRun script, c configuration) (credential, *ClientError) {
+	modeCredential := credential{Name: scriptToRun.Auth.Mode, scope: scriptToRun.ID.String()}
+	switch scriptToRun.Auth.Mode {
+	case authModeGitHub, authModeGitea, authModeHMAC:
+		return modeCredential, checkSignature(r, body, scriptToRun.Auth.signatureSettings())
+	case authModeGitLab:
+		return modeCredential, checkSecretHeader(r.Header.Get("X-Gitlab-Token"), scriptToRun.Auth.Secret)
+	default:
+		return checkToken(r.Header.Get("Authorization"), scriptToRun.credentials(c))
+	}
+}
+
+func checkToken(authHeader string, credentials []credential) (credential, *ClientError) {
+	if authHeader == "" {
+		return credential{}, &ClientError{Message: "Missing authorization token", HTTPCode: http.StatusUnauthorized}
+	}
+
+	for _, cr := range credentials {
+		if !cr.matches(authHeader) {
+			continue
+		}
+		if cr.Disabled {
+			log.WithFields(log.Fields{"Credential": cr.Name}).Warning("Disabled credential used")
+			break
+		}
+		if cr.isExpired() {
+			log.WithFields(log.Fields{"Credential": cr.Name, "ExpiresAt": cr.ExpiresAt}).Warning("Expired credential used")
+			break
+		}
+		return cr, nil
+	}
+	return credential{}, &ClientError{Message: "Invalid authorization token", HTTPCode: http.StatusUnauthorized}
+}
+
+func checkSecretHeader(value string, secret string) *ClientError {
+	if value == "" {
+		return &ClientError{Message: "Missing authorization token", HTTPCode: http.StatusUnauthorized}
+	}
+	if subtle.ConstantTimeCompare([]byte(value), []byte(secret)) != 1 {
+		return &ClientError{Message: "Invalid authorization token", HTTPCode: http.StatusUnauthorized}
+	}
+	return nil
+}
+
+func checkSignature(r *http.Request, body []byte, settings authentication) *ClientError {
+	signature := r.Header.Get(settings.Header)
+	if signature == "" {
+		return &ClientError{Message: "Missing signature", HTTPCode: http.StatusUnauthorized}
+	}
+
+	signature, hasPrefix := strings.CutPrefix(signature, settings.Prefix)
+	if !hasPrefix {
+		return &ClientError{Message: "Invalid signature", HTTPCode: http.StatusUnauthorized}
+	}
+
+	var decoded []byte
+	var err error
+	if settings.getEncoding() == "base64" {
+		decoded, err = base64.StdEncoding.DecodeString(signature)
+	} else {
+		decoded, err = hex.DecodeString(signature)
+	}
+	if err != nil {
+		return &ClientError{Message: "Invalid signature", HTTPCode: http.StatusUnauthorized}
+	}
+
+	mac := hmac.New(hmacAlgorithms[settings.getAlgorithm()], []byte(settings.Secret))
+	mac.Write(body)
+	if !hmac.Equal(decoded, mac.Sum(nil)) {
+		return &ClientError{Message: "Invalid signature", HTTPCode: http.StatusUnauthorized}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAuthorization(t *testing.T) {
+	body := []byte(`{"ref": "refs/heads/main"}`)
+	sha256Mac := hmac.New(sha256.New, []byte("secret"))
+	sha256Mac.Write(body)
+	githubSignature := "sha256=" + hex.EncodeToString(sha256Mac.Sum(nil))
+	sha1Mac := hmac.New(sha1.New, []byte("secret"))
+	sha1Mac.Write(body)
+	base64Signature := base64.StdEncoding.EncodeToString(sha1Mac.Sum(nil))
+
+	tests := []struct {
+		name            string
+		auth            authentication
+		headers         map[string]string
+		expectedMessage string
+	}{
+		{
+			"When GitHub signs the body with the secret then it should be authorized",
+			authentication{Mode: authModeGitHub, Secret: "secret"},
+			map[string]string{"X-Hub-Signature-256": githubSignature},
+			"",
+		},
+		{
+			"When Gitea signs the body with the secret then it should be authorized",
+			authentication{Mode: authModeGitea, Secret: "secret"},
+			map[string]string{"X-Hub-Signature-256": githubSignature},
+			"",
+		},
+		{
+			"When the signature was made with another secret then it should not be authorized",
+			authentication{Mode: authModeGitHub, Secret: "another secret"},
+			map[string]string{"X-Hub-Signature-256": githubSignature},
+			"Invalid signature",
+		},
+		{
+			"When the signature is missing then it should not be authorized",
+			authentication{Mode: authModeGitHub, Secret: "secret"},
+			map[string]string{"Authorization": "test"},
+			"Missing signature",
+		},
+		{
+			"When the signature is not hex encoded then it should not be authorized",
+			authentication{Mode: authModeGitHub, Secret: "secret"},
+			map[string]string{"X-Hub-Signature-256": "sha256=nothex"},
+			"Invalid signature",
+		},
+		{
+			"When GitLab sends the secret token then it should be authorized",
+			authentication{Mode: authModeGitLab, Secret: "secret"},
+			map[string]string{"X-Gitlab-Token": "secret"},
+			"",
+		},
+		{
+			"When GitLab sends another token then it should not be authorized",
+			authentication{Mode: authModeGitLab, Secret: "secret"},
+			map[string]string{"X-Gitlab-Token": "test"},
+			"Invalid authorization token",
+		},
+		{
+			"When a custom HMAC header matches then it should be authorized",
+			authentication{Mode: authModeHMAC, Secret: "secret", Header: "X-Signature", Algorithm: "sha1", Encoding: "base64"},
+			map[string]string{"X-Signature": base64Signature},
+			"",
+		},
+		{
+			"When the default mode is used then the Authorization header should be checked",
+			authentication{},
+			map[string]string{"X-Hub-Signature-256": githubSignature},
+			"Missing authorization token",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/hook", nil)
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+			_, cliErr := checkAuthorization(req, body, script{Auth: test.auth}, configuration{DefaultToken: "test"})
+			if test.expectedMessage == "" {
+				assert.Nil(t, cliErr)
+				return
+			}
+			if assert.NotNil(t, cliErr) {
+				assert.Equal(t, test.expectedMessage, cliErr.Message)
+				assert.Equal(t, http.StatusUnauthorized, cliErr.HTTPCode)
+			}
+		})
+	}
+}
+
+func TestAuthenticationIsValid(t *testing.T) {
+	assert.True(t, authentication{}.isValid())
+	assert.True(t, authentication{Mode: authModeGitHub, Secret: "secret"}.isValid())
+	assert.True(t, authentication{Mode: authModeHMAC, Secret: "secret", Header: "X-Signature"}.isValid())
+	assert.False(t, authentication{Mode: authModeGitHub}.isValid())
+	assert.False(t, authentication{Mode: "bitbucket", Secret: "secret"}.isValid())
+	assert.False(t, authentication{Mode: authModeHMAC, Secret: "secret"}.isValid())
+	assert.False(t, authentication{Mode: authModeHMAC, Secret: "secret", Header: "X-Signature", Algorithm: "md5"}.isValid())
+}
+
+func TestCheckToken(t *testing.T) {
+	c := configuration{
+		DefaultToken: "test",
+		DefaultTokens: []credential{
+			{Name: "ci", Token: "ci-token"},
+			{Name: "cron", TokenHash: "sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"},
+			{Name: "old-bot", Token: "old-token", Disabled: true},
+			{Name: "contractor", Token: "contractor-token", ExpiresAt: time.Now().Add(-time.Hour)},
+			{Name: "on-call", Token: "on-call-token", ExpiresAt: time.Now().Add(time.Hour)},
+		},
+	}
+	tests := []struct {
+		name               string
+		script             script
+		token              string
+		expectedCredential string
+		expectedMessage    string
+	}{
+		{"When the default token is used then its credential should be reported", script{}, "test", "default_token", ""},
+		{"When a named default token is used then its name should be reported", script{}, "ci-token", "ci", ""},
+		{"When a hashed default token is used then its name should be reported", script{}, "secret", "cron", ""},
+		{"When a token that has not expired is used then it should be authorized", script{}, "on-call-token", "on-call", ""},
+		{"When a disabled token is used then it should not be authorized", script{}, "old-token", "", "Invalid authorization token"},
+		{"When an expired token is used then it should not be authorized", script{}, "contractor-token", "", "Invalid authorization token"},
+		{"When a script has its own tokens then the default ones should not be accepted", script{Tokens: []credential{{Name: "deployer", Token: "deploy-token"}}}, "ci-token", "", "Invalid authorization token"},
+		{"When a script has its own tokens then they should be accepted", script{Tokens: []credential{{Name: "deployer", Token: "deploy-token"}}}, "deploy-token", "deployer", ""},
+		{"When a script has a single token then it should be reported as token", script{Token: "nonya"}, "nonya", "token", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/hook", nil)
+			req.Header.Set("Authorization", test.token)
+			usedCredential, cliErr := checkAuthorization(req, nil, test.script, c)
+			assert.Equal(t, test.expectedCredential, usedCredential.Name)
+			if test.expectedMessage == "" {
+				assert.Nil(t, cliErr)
+			} else if assert.NotNil(t, cliErr) {
+				assert.Equal(t, test.expectedMessage, cliErr.Message)
+			}
+		})
+	}
+}
+
+func TestAreValidCredentials(t *testing.T) {
+	assert.True(t, areValidCredentials([]credential{{Name: "ci", Token: "a"}, {Name: "cron", TokenHash: "sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"}}))
+	assert.False(t, areValidCredentials([]credential{{Token: "a"}}))
+	assert.False(t, areValidCredentials([]credential{{Name: "ci"}}))
+	assert.False(t, areValidCredentials([]credential{{Name: "ci", Token: "a"}, {Name: "ci", Token: "b"}}))
+	assert.False(t, areValidCredentials([]credential{{Name: "ci", Token: "a", TokenHash: "sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"}}))
+}
@@ -1,15 +1,212 @@
 package main
 
 import (
-	"github.com/google/uuid"
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+const (
+	whenBusyWait     = "wait"
+	whenBusyReject   = "reject"
+	whenBusyCoalesce = "coalesce"
+)
+
+var (
+	whenBusyModes = []string{whenBusyWait, whenBusyReject, whenBusyCoalesce}
+	errQueueFull  = errors.New("too many executions waiting for this script, try again later")
+	errScriptBusy = errors.New("script is already running")
 )
 
-func getLocks(c configuration) map[uuid.UUID]*sync.Mutex {
-	locks := make(map[uuid.UUID]*sync.Mutex)
+// executionGate limits how many executions of a script run at the same time and how many can wait
+// for their turn. Waiting executions don't hold a goroutine each in a mutex, they can give up when
+// their request goes away.
+type executionGate struct {
+	// slots has one element per running execution, it is nil when the number is unlimited
+	slots    chan struct{}
+	maxQueue int
+	// pending counts the running executions plus the ones waiting
+	pending atomic.Int64
+
+	mu sync.Mutex
+	// followUps has, per script, the job that will run once for every request coalesced while it was busy
+	followUps map[uuid.UUID]uuid.UUID
+}
+
+func newExecutionGate(maxConcurrency int, maxQueue int) *executionGate {
+	g := &executionGate{maxQueue: maxQueue, followUps: make(map[uuid.UUID]uuid.UUID)}
+	if maxConcurrency > 0 {
+		g.slots = make(chan struct{}, maxConcurrency)
+	}
+	return g
+}
+
+func (g *executionGate) hasLimits(maxConcurrency int, maxQueue int) bool {
+	return cap(g.slots) == maxConcurrency && g.maxQueue == maxQueue
+}
+
+// join reserves a place for an execution, failing with errQueueFull when all of them are taken.
+// Every successful join must be followed by a call to wait.
+func (g *executionGate) join() error {
+	if g.slots == nil {
+		return nil
+	}
+	pending := g.pending.Add(1)
+	if g.maxQueue > 0 && pending > int64(cap(g.slots)+g.maxQueue) {
+		g.pending.Add(-1)
+		return errQueueFull
+	}
+	return nil
+}
+
+// wait blocks until the execution can run and returns the function that ends it
+func (g *executionGate) wait(ctx context.Context) (func(), error) {
+	if g.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case g.slots <- struct{}{}:
+		return func() {
+			<-g.slots
+			g.pending.Add(-1)
+		}, nil
+	case <-ctx.Done():
+		g.pending.Add(-1)
+		return nil, ctx.Err()
+	}
+}
+
+// tryAcquire starts an execution only if it doesn't have to wait, returning the function that ends it
+func (g *executionGate) tryAcquire() (func(), bool) {
+	if g.slots == nil {
+		return func() {}, true
+	}
+	select {
+	case g.slots <- struct{}{}:
+		g.pending.Add(1)
+		return func() {
+			<-g.slots
+			g.pending.Add(-1)
+		}, true
+	default:
+		return nil, false
+	}
+}
+
+// coalesce returns the follow-up job of a busy script, creating it with create if there is none waiting.
+// The second value tells if the job is new, in which case the caller must run it after calling wait
+// and followUpStarted.
+func (g *executionGate) coalesce(scriptID uuid.UUID, create func() uuid.UUID) (uuid.UUID, bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if jobID, found := g.followUps[scriptID]; found {
+		return jobID, false, nil
+	}
+	if err := g.join(); err != nil {
+		return uuid.UUID{}, false, err
+	}
+	jobID := create()
+	g.followUps[scriptID] = jobID
+	return jobID, true, nil
+}
+
+// followUpStarted lets requests that arrive while the follow-up job runs schedule another one
+func (g *executionGate) followUpStarted(scriptID uuid.UUID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.followUps, scriptID)
+}
+
+// waitForScript waits for the turn of the script in gate, which must have been joined, and then for its lock file
+func waitForScript(ctx context.Context, scriptToRun script, gate *executionGate) (func(), error) {
+	unlock, err := gate.wait(ctx)
+	if err != nil || scriptToRun.LockFile == "" {
+		return unlock, err
+	}
+	unlockFile, err := lockFile(ctx, scriptToRun.LockFile)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		unlock()
+	}, nil
+}
+
+// tryAcquireScript starts an execution of the script only if it doesn't have to wait, failing with errScriptBusy otherwise
+func tryAcquireScript(scriptToRun script, gate *executionGate) (func(), error) {
+	unlock, acquired := gate.tryAcquire()
+	if !acquired {
+		return nil, errScriptBusy
+	}
+	if scriptToRun.LockFile == "" {
+		return unlock, nil
+	}
+	unlockFile, err := tryLockFile(scriptToRun.LockFile)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		unlock()
+	}, nil
+}
+
+// concurrencyLimits returns how many executions of the script can run at the same time and how many can wait,
+// zero meaning no limit. Without max_concurrency, concurrent scripts have no limit and the rest run one at a time.
+func (s script) concurrencyLimits() (int, int) {
+	if s.MaxConcurrency > 0 {
+		return s.MaxConcurrency, s.MaxQueue
+	}
+	if s.Concurrent {
+		return 0, 0
+	}
+	return 1, s.MaxQueue
+}
+
+// lockKey identifies the gate of the script: the one of its lock group or its own one
+func (s script) lockKey() string {
+	if s.LockGroup != "" {
+		return "group:" + s.LockGroup
+	}
+	return "script:" + s.ID.String()
+}
+
+// checkLockGroups makes sure the scripts that share a lock group agree on its limits
+func checkLockGroups(scripts []script) error {
+	limits := make(map[string][2]int)
+	for _, s := range scripts {
+		if s.LockGroup == "" {
+			continue
+		}
+		maxConcurrency, maxQueue := s.concurrencyLimits()
+		if previous, found := limits[s.LockGroup]; found && previous != [2]int{maxConcurrency, maxQueue} {
+			return fmt.Errorf("inconsistent concurrency settings in lock group: %s", s.LockGroup)
+		}
+		limits[s.LockGroup] = [2]int{maxConcurrency, maxQueue}
+	}
+	return nil
+}
+
+// getLocks creates a gate for every script or lock group, reusing the ones in previous when the limits didn't change
+func getLocks(c configuration, previous map[string]*executionGate) map[string]*executionGate {
+	locks := make(map[string]*executionGate)
 	for _, ascript := range c.Scripts {
-		if !ascript.Concurrent {
-			locks[ascript.ID] = new(sync.Mutex)
+		key := ascript.lockKey()
+		if _, found := locks[key]; found {
+			continue
+		}
+		maxConcurrency, maxQueue := ascript.concurrencyLimits()
+		if gate, found := previous[key]; found && gate.hasLimits(maxConcurrency, maxQueue) {
+			locks[key] = gate
+		} else {
+			locks[key] = newExecutionGate(maxConcurrency, maxQueue)
 		}
 	}
 	return locks
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutionGate(t *testing.T) {
+	gate := newExecutionGate(2, 1)
+
+	require.NoError(t, gate.join())
+	first, err := gate.wait(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, gate.join())
+	second, err := gate.wait(context.Background())
+	require.NoError(t, err)
+
+	acquired := make(chan func())
+	require.NoError(t, gate.join())
+	go func() {
+		third, err := gate.wait(context.Background())
+		assert.NoError(t, err)
+		acquired <- third
+	}()
+	assert.Eventually(t, func() bool { return gate.pending.Load() == 3 }, time.Second, 10*time.Millisecond)
+
+	assert.ErrorIs(t, gate.join(), errQueueFull)
+
+	first()
+	third := <-acquired
+	second()
+	third()
+	assert.Equal(t, int64(0), gate.pending.Load())
+}
+
+func TestExecutionGateGivesUpWhenContextIsDone(t *testing.T) {
+	gate := newExecutionGate(1, 0)
+	require.NoError(t, gate.join())
+	unlock, err := gate.wait(context.Background())
+	require.NoError(t, err)
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.NoError(t, gate.join())
+	_, err = gate.wait(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int64(1), gate.pending.Load())
+}
+
+func TestConcurrencyLimits(t *testing.T) {
+	tests := []struct {
+		name                   string
+		script                 script
+		expectedMaxConcurrency int
+		expectedMaxQueue       int
+	}{
+		{"When nothing is set then it should run one at a time", script{}, 1, 0},
+		{"When the script is concurrent then it should have no limit", script{Concurrent: true}, 0, 0},
+		{"When max_queue is set then it should apply to the single execution", script{MaxQueue: 3}, 1, 3},
+		{"When max_concurrency is set then it should override concurrent", script{Concurrent: true, MaxConcurrency: 4, MaxQueue: 2}, 4, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			maxConcurrency, maxQueue := test.script.concurrencyLimits()
+			assert.Equal(t, test.expectedMaxConcurrency, maxConcurrency)
+			assert.Equal(t, test.expectedMaxQueue, maxQueue)
+		})
+	}
+}
+
+func TestGetLocksReplacesGatesWhoseLimitsChanged(t *testing.T) {
+	kept := script{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Inline: "echo kept"}
+	changed := script{ID: parseUUIDOrPanic("b9f71a96-0d23-11ee-860e-ff55b106c448"), Inline: "echo changed"}
+	previous := getLocks(configuration{Scripts: []script{kept, changed}}, nil)
+
+	changed.MaxConcurrency = 3
+	locks := getLocks(configuration{Scripts: []script{kept, changed}}, previous)
+
+	assert.Same(t, previous[kept.lockKey()], locks[kept.lockKey()])
+	assert.NotSame(t, previous[changed.lockKey()], locks[changed.lockKey()])
+	assert.Equal(t, 3, cap(locks[changed.lockKey()].slots))
+}
+
+func TestQueueFull(t *testing.T) {
+	scriptID := parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	srv := newServer(configuration{DefaultToken: "test", Scripts: []script{{ID: scriptID, Inline: "echo ok", MaxQueue: 1}}})
+	_, locks := srv.current()
+	gate := locks["script:"+scriptID.String()]
+
+	require.NoError(t, gate.join())
+	unlock, err := gate.wait(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, gate.join(), "the queue should have room for one execution")
+
+	for _, query := range []string{"", "&async=true"} {
+		req, _ := http.NewRequest("GET", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde"+query, nil)
+		req.Header.Set("Authorization", "test")
+		rr := httptest.NewRecorder()
+		srv.router().ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	}
+
+	unlock()
+	waiting, err := gate.wait(context.Background())
+	require.NoError(t, err)
+	waiting()
+}
+
+func TestWhenBusy(t *testing.T) {
+	scriptID := parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	callHook := func(srv *server) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", nil)
+		req.Header.Set("Authorization", "test")
+		rr := httptest.NewRecorder()
+		srv.router().ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("When the script is busy and when_busy is reject then it should return 409", func(t *testing.T) {
+		srv := newServer(configuration{DefaultToken: "test", Scripts: []script{{ID: scriptID, Inline: "echo ok", WhenBusy: whenBusyReject}}})
+		_, locks := srv.current()
+		gate := locks["script:"+scriptID.String()]
+		require.NoError(t, gate.join())
+		unlock, err := gate.wait(context.Background())
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusConflict, callHook(srv).Code)
+		unlock()
+		assert.Equal(t, http.StatusOK, callHook(srv).Code)
+	})
+
+	t.Run("When the script is busy and when_busy is coalesce then requests should share one follow-up job", func(t *testing.T) {
+		srv := newServer(configuration{DefaultToken: "test", Scripts: []script{{ID: scriptID, Inline: "echo ok", WhenBusy: whenBusyCoalesce}}})
+		_, locks := srv.current()
+		gate := locks["script:"+scriptID.String()]
+		require.NoError(t, gate.join())
+		unlock, err := gate.wait(context.Background())
+		require.NoError(t, err)
+
+		first := callHook(srv)
+		second := callHook(srv)
+		assert.Equal(t, http.StatusAccepted, first.Code)
+		assert.Equal(t, http.StatusAccepted, second.Code)
+		assert.NotEmpty(t, first.Header().Get("Location"))
+		assert.Equal(t, first.Header().Get("Location"), second.Header().Get("Location"))
+
+		unlock()
+		jobID := parseUUIDOrPanic(first.Header().Get("Location")[len("/jobs/"):])
+		assert.Eventually(t, func() bool {
+			j, _ := srv.jobs.get(jobID)
+			return j.Status == jobSucceeded
+		}, 5*time.Second, 10*time.Millisecond)
+		j, _ := srv.jobs.get(jobID)
+		assert.Equal(t, "ok\n", j.Stdout)
+
+		third := callHook(srv)
+		assert.Equal(t, http.StatusOK, third.Code, "the script should run right away once it is idle")
+	})
+}
+
+func TestLockGroups(t *testing.T) {
+	deploy := script{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Inline: "echo deploy", LockGroup: "app", WhenBusy: whenBusyReject}
+	rollback := script{ID: parseUUIDOrPanic("b9f71a96-0d23-11ee-860e-ff55b106c448"), Inline: "echo rollback", LockGroup: "app"}
+	other := script{ID: parseUUIDOrPanic("5e5adb92-0d04-11ee-97cf-4b6c30e50f6a"), Inline: "echo other"}
+	srv := newServer(configuration{DefaultToken: "test", Scripts: []script{deploy, rollback, other}})
+	_, locks := srv.current()
+	assert.Len(t, locks, 2)
+	assert.Same(t, locks[deploy.lockKey()], locks[rollback.lockKey()])
+
+	require.NoError(t, locks[rollback.lockKey()].join())
+	unlock, err := locks[rollback.lockKey()].wait(context.Background())
+	require.NoError(t, err)
+	defer unlock()
+
+	for _, test := range []struct {
+		scriptID     string
+		expectedCode int
+	}{
+		{deploy.ID.String(), http.StatusConflict},
+		{other.ID.String(), http.StatusOK},
+	} {
+		req, _ := http.NewRequest("GET", "/hook?script="+test.scriptID, nil)
+		req.Header.Set("Authorization", "test")
+		rr := httptest.NewRecorder()
+		srv.router().ServeHTTP(rr, req)
+		assert.Equal(t, test.expectedCode, rr.Code)
+	}
+}
+
+func TestCheckLockGroups(t *testing.T) {
+	deploy := script{LockGroup: "app"}
+	assert.NoError(t, checkLockGroups([]script{deploy, {LockGroup: "app"}, {Concurrent: true}}))
+	assert.Error(t, checkLockGroups([]script{deploy, {LockGroup: "app", MaxConcurrency: 2}}))
+	assert.Error(t, checkLockGroups([]script{deploy, {LockGroup: "app", Concurrent: true}}))
+}
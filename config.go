@@ -5,17 +5,46 @@ import (
 	"github.com/google/uuid"
 	"gopkg.in/yaml.v3"
 	"os"
+	"regexp"
+	"slices"
+	"time"
 )
 
 type script struct {
-	ID          uuid.UUID     `yaml:"id"`
-	Path        string        `yaml:"path,omitempty"`
-	Inline      string        `yaml:"inline,omitempty"`
-	Token       string        `yaml:"token,omitempty"`
-	Concurrent  bool          `yaml:"concurrent"`
-	Shell       string        `yaml:"shell"`
-	User        string        `yaml:"user"`
-	Environment []environment `yaml:"environment"`
+	ID              uuid.UUID      `yaml:"id"`
+	Name            string         `yaml:"name,omitempty"`
+	Path            string         `yaml:"path,omitempty"`
+	Inline          string         `yaml:"inline,omitempty"`
+	Token           string         `yaml:"token,omitempty"`
+	TokenHash       string         `yaml:"token_hash,omitempty"`
+	Tokens          []credential   `yaml:"tokens"`
+	Concurrent      bool           `yaml:"concurrent"`
+	MaxConcurrency  int            `yaml:"max_concurrency,omitempty"`
+	MaxQueue        int            `yaml:"max_queue,omitempty"`
+	WhenBusy        string         `yaml:"when_busy,omitempty"`
+	LockGroup       string         `yaml:"lock_group,omitempty"`
+	LockFile        string         `yaml:"lock_file,omitempty"`
+	ResponseFormat  string         `yaml:"response_format,omitempty"`
+	ExitCodes       map[string]int `yaml:"exit_codes,omitempty"`
+	ReturnStderr    bool           `yaml:"return_stderr"`
+	HideOutput      bool           `yaml:"hide_output"`
+	MaxOutputBytes  int64          `yaml:"max_output_bytes,omitempty"`
+	KeepOutput      string         `yaml:"keep_output,omitempty"`
+	Shell           string         `yaml:"shell"`
+	User            string         `yaml:"user"`
+	Environment     []environment  `yaml:"environment"`
+	Timeout         time.Duration  `yaml:"timeout,omitempty"`
+	Async           bool           `yaml:"async"`
+	Stream          bool           `yaml:"stream"`
+	Parameters      []parameter    `yaml:"parameters"`
+	Stdin           bool           `yaml:"stdin"`
+	MaxBodySize     int64          `yaml:"max_body_size,omitempty"`
+	Headers         []string       `yaml:"headers"`
+	Auth            authentication `yaml:"auth"`
+	Methods         []string       `yaml:"methods"`
+	AllowedNetworks []string       `yaml:"allowed_networks"`
+	DeniedNetworks  []string       `yaml:"denied_networks"`
+	RateLimit       rateLimit      `yaml:"rate_limit,omitempty"`
 }
 
 type environment struct {
@@ -23,14 +52,64 @@ type environment struct {
 	Value string `yaml:"value"`
 }
 
+var scriptNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
 func (s script) isValid() bool {
+	if s.Name != "" && !scriptNameRegexp.MatchString(s.Name) {
+		return false
+	}
+	if !areValidMethods(s.Methods) {
+		return false
+	}
+	if !areValidNetworks(s.AllowedNetworks) || !areValidNetworks(s.DeniedNetworks) {
+		return false
+	}
+	if !s.RateLimit.isValid() {
+		return false
+	}
+	if s.MaxConcurrency < 0 || s.MaxQueue < 0 {
+		return false
+	}
+	if s.WhenBusy != "" && !slices.Contains(whenBusyModes, s.WhenBusy) {
+		return false
+	}
+	if maxConcurrency, _ := s.concurrencyLimits(); s.LockFile != "" && maxConcurrency != 1 {
+		return false
+	}
+	if !isValidResponseFormat(s.ResponseFormat) || !isValidExitCodes(s.ExitCodes) || !isValidOutputLimit(s.MaxOutputBytes, s.KeepOutput) {
+		return false
+	}
+	if !s.Auth.isValid() {
+		return false
+	}
+	if s.TokenHash != "" && (s.Token != "" || !isValidTokenHash(s.TokenHash)) {
+		return false
+	}
+	if !areValidCredentials(s.Tokens) {
+		return false
+	}
+	for _, p := range s.Parameters {
+		if !p.isValid() {
+			return false
+		}
+	}
 	return (s.Path != "" && s.Inline == "") || (s.Path == "" && s.Inline != "")
 }
 
 type configuration struct {
-	DefaultToken string        `yaml:"default_token"`
-	Scripts      []script      `yaml:"scripts"`
-	Environment  []environment `yaml:"environment"`
+	DefaultToken     string        `yaml:"default_token"`
+	DefaultTokenHash string        `yaml:"default_token_hash,omitempty"`
+	DefaultTokens    []credential  `yaml:"default_tokens"`
+	Scripts          []script      `yaml:"scripts"`
+	Environment      []environment `yaml:"environment"`
+	JobRetention     time.Duration `yaml:"job_retention,omitempty"`
+	Methods          []string      `yaml:"methods"`
+	AllowedNetworks  []string      `yaml:"allowed_networks"`
+	DeniedNetworks   []string      `yaml:"denied_networks"`
+	TrustedProxies   []string      `yaml:"trusted_proxies"`
+	RateLimit        rateLimit     `yaml:"rate_limit,omitempty"`
+	ClientRateLimit  rateLimit     `yaml:"client_rate_limit,omitempty"`
+	MaxOutputBytes   int64         `yaml:"max_output_bytes,omitempty"`
 }
 
 func getConfig(configFile string) (configuration, error) {
@@ -45,10 +124,45 @@ func getConfig(configFile string) (configuration, error) {
 		return configuration{}, err
 	}
 
+	if c.DefaultTokenHash != "" && (c.DefaultToken != "" || !isValidTokenHash(c.DefaultTokenHash)) {
+		return configuration{}, fmt.Errorf("invalid default_token_hash: use either default_token or a bcrypt, argon2id or sha256 hash")
+	}
+
+	if !areValidCredentials(c.DefaultTokens) {
+		return configuration{}, fmt.Errorf("invalid default_tokens: every token needs a unique name and either a token or a valid token_hash")
+	}
+
+	if !areValidMethods(c.Methods) {
+		return configuration{}, fmt.Errorf("invalid methods: %v", c.Methods)
+	}
+
+	if !areValidNetworks(c.AllowedNetworks) || !areValidNetworks(c.DeniedNetworks) || !areValidNetworks(c.TrustedProxies) {
+		return configuration{}, fmt.Errorf("invalid networks: use CIDR ranges or IP addresses")
+	}
+
+	if !c.RateLimit.isValid() || !c.ClientRateLimit.isValid() {
+		return configuration{}, fmt.Errorf("invalid rate limit: requests and interval must be positive")
+	}
+
+	if c.MaxOutputBytes < 0 {
+		return configuration{}, fmt.Errorf("invalid max_output_bytes: %d", c.MaxOutputBytes)
+	}
+
+	names := make(map[string]bool)
 	for _, s := range c.Scripts {
 		if !s.isValid() {
 			return configuration{}, fmt.Errorf("invalid script: %v", s)
 		}
+		if s.Name != "" {
+			if names[s.Name] {
+				return configuration{}, fmt.Errorf("duplicated script name: %s", s.Name)
+			}
+			names[s.Name] = true
+		}
+	}
+
+	if err := checkLockGroups(c.Scripts); err != nil {
+		return configuration{}, err
 	}
 
 	return c, nil
@@ -69,3 +183,18 @@ func (c *configuration) getScript(scriptUUID string) (script, error) {
 
 	return script{}, fmt.Errorf("invalid script ID: %s", scriptUUID)
 }
+
+// getHook finds a script by its name or, if no script has that name, by its ID
+func (c *configuration) getHook(nameOrUUID string) (script, error) {
+	for _, script := range c.Scripts {
+		if script.Name != "" && script.Name == nameOrUUID {
+			return script, nil
+		}
+	}
+
+	script, err := c.getScript(nameOrUUID)
+	if err != nil {
+		return script, fmt.Errorf("hook not found: %s", nameOrUUID)
+	}
+	return script, nil
+}
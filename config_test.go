@@ -4,6 +4,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -13,6 +15,7 @@ func TestConfigurationLoadsCorrectly(t *testing.T) {
 	scriptUUID, err := uuid.Parse("5e5adb92-0d04-11ee-97cf-4b6c30e50f6a")
 	require.NoError(t, err)
 	assert.Equal(t, "KXjk9waX9fqRLQ4t8sQf5IK94e2u1CXr8X4MscDc", c.DefaultToken)
+	assert.Empty(t, c.DefaultTokens)
 	assert.Len(t, c.Scripts, 4)
 	assert.Equal(t, scriptUUID, c.Scripts[0].ID)
 	assert.Equal(t, "./scripts/success.sh", c.Scripts[0].Path)
@@ -23,6 +26,32 @@ func TestConfigurationLoadsCorrectly(t *testing.T) {
 	assert.Equal(t, []environment{{Key: "NAME", Value: "Frodo"}}, c.Scripts[3].Environment)
 }
 
+func TestConfigurationLoadsNamedTokens(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `default_tokens:
+  - name: ci
+    token: Vd0Bq6b9nJ0lYgF1r8VhWm3aXkRz2TpS5uC7eQyN
+    expires_at: 2030-01-01T00:00:00Z
+    rate_limit:
+      requests: 30
+      interval: 1m
+  - name: old-bot
+    token_hash: sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b
+    disabled: true
+scripts:
+  - id: 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a
+    inline: echo ok
+`
+	require.NoError(t, os.WriteFile(configFile, []byte(config), 0600))
+	c, err := getConfig(configFile)
+	require.NoError(t, err)
+	require.Len(t, c.DefaultTokens, 2)
+	assert.Equal(t, "ci", c.DefaultTokens[0].Name)
+	assert.Equal(t, 2030, c.DefaultTokens[0].ExpiresAt.Year())
+	assert.Equal(t, 30, c.DefaultTokens[0].RateLimit.Requests)
+	assert.True(t, c.DefaultTokens[1].Disabled)
+}
+
 func TestConfigurationFailsOnInvalidScript(t *testing.T) {
 	_, err := getConfig("bad_config.yaml")
 	require.Error(t, err)
@@ -48,3 +77,49 @@ func TestGetScript(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, "invalid script ID: ", err.Error())
 }
+
+func TestGetHook(t *testing.T) {
+	c := configuration{Scripts: []script{
+		{ID: parseUUIDOrPanic("5e5adb92-0d04-11ee-97cf-4b6c30e50f6a"), Name: "deploy"},
+		{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")},
+	}}
+
+	script, err := c.getHook("deploy")
+	assert.NoError(t, err)
+	assert.Equal(t, "5e5adb92-0d04-11ee-97cf-4b6c30e50f6a", script.ID.String())
+
+	script, err = c.getHook("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	assert.NoError(t, err)
+	assert.Equal(t, "47878e38-a700-11ee-bc6d-f3d25921fcde", script.ID.String())
+
+	_, err = c.getHook("rollback")
+	assert.EqualError(t, err, "hook not found: rollback")
+}
+
+func TestConfigurationFailsOnInvalidScriptNames(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        string
+		expectedError string
+	}{
+		{
+			"When two scripts have the same name then it should fail",
+			"scripts:\n  - id: 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a\n    name: deploy\n    inline: echo ok\n  - id: 47878e38-a700-11ee-bc6d-f3d25921fcde\n    name: deploy\n    inline: echo ok\n",
+			"duplicated script name: deploy",
+		},
+		{
+			"When a name can't be used in a URL path then it should fail",
+			"scripts:\n  - id: 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a\n    name: deploy/prod\n    inline: echo ok\n",
+			"invalid script",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(configFile, []byte(test.config), 0600))
+			_, err := getConfig(configFile)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectedError)
+		})
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/user"
@@ -14,14 +17,40 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func executeScript(scriptToRun script, globalEnvironment []environment) ([]byte, error) {
+var errScriptTimeout = errors.New("script execution timed out")
+
+type executionResult struct {
+	Stdout    []byte
+	Stderr    []byte
+	ExitCode  int
+	StartedAt time.Time
+	Duration  time.Duration
+	// Truncated tells if part of the output was dropped because of max_output_bytes
+	Truncated bool
+}
+
+// executionOptions carries what a single execution gets from the request that triggered it
+type executionOptions struct {
+	// stdout and stderr also receive the output as it is produced, besides it being kept in the result
+	stdout io.Writer
+	stderr io.Writer
+	// environment holds the variables taken from the request, such as its validated parameters
+	environment []environment
+	stdin       io.Reader
+	// maxOutputBytes limits how much of stdout and stderr is kept in the result, 0 meaning no limit
+	maxOutputBytes int64
+}
+
+func executeScript(scriptToRun script, globalEnvironment []environment, opts executionOptions) (executionResult, error) {
+	result := executionResult{ExitCode: -1}
 	shell := getShell(scriptToRun)
 	scriptPath := scriptToRun.Path
 
 	if scriptToRun.Inline != "" {
 		tempScript, err := createTemporaryScriptFromInline(scriptToRun)
 		if err != nil {
-			return nil, err
+			logFailure(log.Fields{"script_id": scriptToRun.ID.String()}, err)
+			return result, err
 		}
 		defer func(name string) {
 			err := os.Remove(name)
@@ -34,30 +63,76 @@ func executeScript(scriptToRun script, globalEnvironment []environment) ([]byte,
 		scriptPath = tempScript
 	}
 
-	cmd := exec.Command(shell, scriptPath)
+	ctx := context.Background()
+	if scriptToRun.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, scriptToRun.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, shell, scriptPath)
 	if scriptToRun.User != "" {
 		err := injectUserInCmd(scriptToRun.User, cmd)
 		if err != nil {
-			return nil, fmt.Errorf("%v for %s", err, scriptToRun.User)
+			err = fmt.Errorf("%v for %s", err, scriptToRun.User)
+			logFailure(log.Fields{"script": scriptPath, "script_id": scriptToRun.ID.String()}, err)
+			return result, err
 		}
 	}
+	runInOwnProcessGroup(cmd)
 
-	injectEnvironmentVariables(scriptToRun.Environment, globalEnvironment, cmd)
+	injectEnvironmentVariables(scriptToRun.Environment, globalEnvironment, opts.environment, cmd)
 
-	startTime := time.Now()
-	output, err := cmd.Output()
-	duration := time.Since(startTime)
+	cmd.Stdin = opts.stdin
+
+	stdout := newTruncatingBuffer(opts.maxOutputBytes, scriptToRun.KeepOutput)
+	stderr := newTruncatingBuffer(opts.maxOutputBytes, scriptToRun.KeepOutput)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if opts.stdout != nil {
+		cmd.Stdout = io.MultiWriter(stdout, opts.stdout)
+	}
+	if opts.stderr != nil {
+		cmd.Stderr = io.MultiWriter(stderr, opts.stderr)
+	}
+
+	result.StartedAt = time.Now()
+	err := cmd.Start()
+	if err == nil {
+		runningExecutions.add(cmd.Process.Pid)
+		err = cmd.Wait()
+		runningExecutions.done(cmd.Process.Pid)
+	}
+	result.Duration = time.Since(result.StartedAt)
+	result.Stdout = stdout.Bytes()
+	result.Stderr = stderr.Bytes()
+	result.Truncated = stdout.isTruncated() || stderr.isTruncated()
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
 	execsTotal.Inc()
-	execDuration.WithLabelValues(scriptToRun.ID.String()).Observe(duration.Seconds())
+	execDuration.WithLabelValues(scriptToRun.ID.String()).Observe(result.Duration.Seconds())
+	fields := log.Fields{"script": scriptPath, "duration": result.Duration.String(), "script_id": scriptToRun.ID.String(), "exit_code": result.ExitCode, "truncated": result.Truncated}
+	log.WithFields(fields).WithFields(log.Fields{"output": string(result.Stdout), "stderr": string(result.Stderr)}).Debug("Script output")
+	if ctx.Err() == context.DeadlineExceeded {
+		timeoutsTotal.Inc()
+		err = fmt.Errorf("%w after %s", errScriptTimeout, scriptToRun.Timeout)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("%s%v", output, err)
+		fields["stderr"] = string(result.Stderr)
+		logFailure(fields, err)
+		return result, err
 	}
-	log.WithFields(log.Fields{"output": string(output), "script": scriptPath, "duration": duration.String(), "script_id": scriptToRun.ID.String()}).Debug("Script output")
-	log.WithFields(log.Fields{"script": scriptPath, "duration": duration.String(), "script_id": scriptToRun.ID.String()}).Info("Script executed")
-	return output, nil
+	log.WithFields(fields).Info("Script executed")
+	return result, nil
+}
+
+// logFailure is the only place failed executions are logged, callers just report them
+func logFailure(fields log.Fields, err error) {
+	log.WithFields(fields).WithField("error", err.Error()).Warning("Script failed")
 }
 
-func injectEnvironmentVariables(scriptEnvironment []environment, globalEnvironment []environment, cmd *exec.Cmd) {
+func injectEnvironmentVariables(scriptEnvironment []environment, globalEnvironment []environment, requestEnvironment []environment, cmd *exec.Cmd) {
 	for _, env := range globalEnvironment {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", env.Key, env.Value))
 	}
@@ -65,6 +140,28 @@ func injectEnvironmentVariables(scriptEnvironment []environment, globalEnvironme
 	for _, env := range scriptEnvironment {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", env.Key, env.Value))
 	}
+
+	// Without configured variables the script inherits the environment of the service, which the
+	// variables of the request must not take away
+	if cmd.Env == nil && len(requestEnvironment) > 0 {
+		cmd.Env = os.Environ()
+	}
+	for _, env := range requestEnvironment {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", env.Key, env.Value))
+	}
+}
+
+// runInOwnProcessGroup starts the command as the leader of a new process group and makes
+// cancellation kill the whole group, so processes spawned by the script don't outlive it.
+func runInOwnProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
 }
 
 func getUser(scriptToRun script) string {
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"testing"
+	"time"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
 )
 
 func TestDetectDefaultShell(t *testing.T) {
@@ -36,3 +41,56 @@ func TestDetectDefaultShell(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteScriptTimeoutKillsProcessGroup(t *testing.T) {
+	scriptToRun := script{Inline: "(sleep 5; echo late) & wait", Shell: "/bin/sh", Timeout: 100 * time.Millisecond}
+
+	startTime := time.Now()
+	_, err := executeScript(scriptToRun, nil, executionOptions{})
+
+	if !errors.Is(err, errScriptTimeout) {
+		t.Fatalf("Expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(startTime); elapsed > 2*time.Second {
+		t.Errorf("Expected the process group to be killed right after the timeout, took %s", elapsed)
+	}
+}
+
+func TestExecuteScriptLogsStderrOfFailures(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	scriptToRun := script{Inline: "echo broken >&2; exit 2", Shell: "/bin/sh"}
+	result, err := executeScript(scriptToRun, nil, executionOptions{})
+	if err == nil {
+		t.Fatal("Expected the script to fail")
+	}
+	if string(result.Stderr) != "broken\n" {
+		t.Errorf("Expected stderr to be captured, got %q", result.Stderr)
+	}
+
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Script failed" {
+			if entry.Data["stderr"] != "broken\n" || entry.Data["exit_code"] != 2 {
+				t.Errorf("Expected the failure to be logged with stderr and exit code, got %v", entry.Data)
+			}
+			return
+		}
+	}
+	t.Error("Expected the failure to be logged")
+}
+
+func TestExecuteScriptKeepsStreamedOutput(t *testing.T) {
+	var streamed bytes.Buffer
+	scriptToRun := script{Inline: "echo out; echo err >&2", Shell: "/bin/sh"}
+	result, err := executeScript(scriptToRun, nil, executionOptions{stdout: &streamed})
+	if err != nil {
+		t.Fatalf("Expected the script to succeed, got %v", err)
+	}
+	if streamed.String() != "out\n" {
+		t.Errorf("Expected stdout to be streamed, got %q", streamed.String())
+	}
+	if string(result.Stdout) != "out\n" || string(result.Stderr) != "err\n" {
+		t.Errorf("Expected the streamed output to be kept in the result, got %q and %q", result.Stdout, result.Stderr)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	hashAlgorithmBcrypt = "bcrypt"
+	hashAlgorithmArgon2 = "argon2id"
+	hashAlgorithmSHA256 = "sha256"
+
+	argon2Memory  = 64 * 1024
+	argon2Time    = 3
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+	// Hashes are verified on requests, so their cost is bounded to keep a typo in the configuration
+	// from making every request allocate gigabytes or spin the CPU
+	argon2MaxMemory = 256 * 1024
+	argon2MaxTime   = 10
+)
+
+var (
+	hashAlgorithms = []string{hashAlgorithmBcrypt, hashAlgorithmArgon2, hashAlgorithmSHA256}
+	// verifiedTokens keeps, per bcrypt or argon2id hash, the SHA-256 of the token that matched it, so
+	// callers with a valid token only pay for the slow hash once
+	verifiedTokens sync.Map
+)
+
+// hashToken produces a hash that can be used as token_hash or default_token_hash in the configuration
+func hashToken(token string, algorithm string) (string, error) {
+	switch algorithm {
+	case hashAlgorithmBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.DefaultCost)
+		return string(hash), err
+	case hashAlgorithmArgon2:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(token), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case hashAlgorithmSHA256:
+		sum := sha256.Sum256([]byte(token))
+		return "sha256:" + hex.EncodeToString(sum[:]), nil
+	default:
+		return "", fmt.Errorf("unknown hash algorithm %s, use one of %s", algorithm, strings.Join(hashAlgorithms, ", "))
+	}
+}
+
+// verifyTokenHash checks a token against a hash produced by hashToken (or any other bcrypt or argon2id tool)
+func verifyTokenHash(token string, hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"), strings.HasPrefix(hash, "$argon2id$"):
+		sum := sha256.Sum256([]byte(token))
+		if verified, found := verifiedTokens.Load(hash); found && subtle.ConstantTimeCompare(verified.([]byte), sum[:]) == 1 {
+			return true
+		}
+		if !verifySlowTokenHash(token, hash) {
+			return false
+		}
+		verifiedTokens.Store(hash, sum[:])
+		return true
+	case strings.HasPrefix(hash, "sha256:"):
+		expected, err := hex.DecodeString(strings.TrimPrefix(hash, "sha256:"))
+		if err != nil {
+			return false
+		}
+		sum := sha256.Sum256([]byte(token))
+		return subtle.ConstantTimeCompare(sum[:], expected) == 1
+	default:
+		return false
+	}
+}
+
+func verifySlowTokenHash(token string, hash string) bool {
+	if strings.HasPrefix(hash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(token)) == nil
+	}
+	params, salt, key, err := parseArgon2Hash(hash)
+	if err != nil {
+		return false
+	}
+	computed := argon2.IDKey([]byte(token), salt, params.time, params.memory, params.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1
+}
+
+func isValidTokenHash(hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		_, err := bcrypt.Cost([]byte(hash))
+		return err == nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		_, _, _, err := parseArgon2Hash(hash)
+		return err == nil
+	case strings.HasPrefix(hash, "sha256:"):
+		decoded, err := hex.DecodeString(strings.TrimPrefix(hash, "sha256:"))
+		return err == nil && len(decoded) == sha256.Size
+	default:
+		return false
+	}
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+func parseArgon2Hash(hash string) (argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2Params{}, nil, nil, fmt.Errorf("unsupported argon2id version %s", parts[2])
+	}
+
+	var params argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id parameters: %v", err)
+	}
+	if params.time == 0 || params.time > argon2MaxTime || params.threads == 0 || params.memory == 0 || params.memory > argon2MaxMemory {
+		return argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id parameters: t must be from 1 to %d, p at least 1 and m from 1 to %d KiB", argon2MaxTime, argon2MaxMemory)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id salt: %v", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id key")
+	}
+	return params, salt, key, nil
+}
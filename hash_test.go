@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashToken(t *testing.T) {
+	for _, algorithm := range hashAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			hash, err := hashToken("test", algorithm)
+			require.NoError(t, err)
+			assert.True(t, isValidTokenHash(hash))
+			assert.True(t, verifyTokenHash("test", hash))
+			assert.False(t, verifyTokenHash("nonya", hash))
+		})
+	}
+
+	_, err := hashToken("test", "md5")
+	assert.Error(t, err)
+}
+
+func TestVerifyTokenHash(t *testing.T) {
+	tests := []struct {
+		name     string
+		hash     string
+		expected bool
+	}{
+		{"bcrypt with $2y$ prefix", "$2y$10$w67ENgJh6GXP9P1liQvC4eNC6UJ0KQGRvaKHyf5dDpfYyCYp5tmkq", true},
+		{"bcrypt with $2a$ prefix", "$2a$10$w67ENgJh6GXP9P1liQvC4eNC6UJ0KQGRvaKHyf5dDpfYyCYp5tmkq", true},
+		{"argon2id", "$argon2id$v=19$m=65536,t=3,p=4$HbBHw9SWs32z3TJdlLThxA$AtB1J9Gpve+SaQUiFJYjtGeiwd8uLwvt4BSM6tm3bnU", true},
+		{"sha256", "sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", true},
+		{"argon2id without passes", "$argon2id$v=19$m=65536,t=0,p=4$HbBHw9SWs32z3TJdlLThxA$AtB1J9Gpve+SaQUiFJYjtGeiwd8uLwvt4BSM6tm3bnU", false},
+		{"argon2id without parallelism", "$argon2id$v=19$m=65536,t=3,p=0$HbBHw9SWs32z3TJdlLThxA$AtB1J9Gpve+SaQUiFJYjtGeiwd8uLwvt4BSM6tm3bnU", false},
+		{"argon2id with too much memory", "$argon2id$v=19$m=67108864,t=3,p=4$HbBHw9SWs32z3TJdlLThxA$AtB1J9Gpve+SaQUiFJYjtGeiwd8uLwvt4BSM6tm3bnU", false},
+		{"unknown format", "md5:5ebe2294ecd0e0f08eab7690d2a6ee69", false},
+		{"plain text", "secret", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, verifyTokenHash("secret", test.hash))
+			assert.Equal(t, test.expected, isValidTokenHash(test.hash))
+		})
+	}
+}
+
+func TestVerifyTokenHashCachesVerifiedTokens(t *testing.T) {
+	hash := "$argon2id$v=19$m=65536,t=3,p=4$HbBHw9SWs32z3TJdlLThxA$AtB1J9Gpve+SaQUiFJYjtGeiwd8uLwvt4BSM6tm3bnU"
+	verifiedTokens.Delete(hash)
+	require.True(t, verifyTokenHash("secret", hash))
+	_, cached := verifiedTokens.Load(hash)
+	assert.True(t, cached)
+	assert.True(t, verifyTokenHash("secret", hash))
+	assert.False(t, verifyTokenHash("nonya", hash))
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultHistoryRetention = 30 * 24 * time.Hour
+	// historyOutputBytes limits the output stored for every execution, keeping its beginning and its end
+	historyOutputBytes  = 64 << 10
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// execution is the record of a run kept in the history
+type execution struct {
+	ID         uuid.UUID         `json:"id"`
+	ScriptID   uuid.UUID         `json:"script_id"`
+	ScriptName string            `json:"script_name,omitempty"`
+	JobID      *uuid.UUID        `json:"job_id,omitempty"`
+	Credential string            `json:"credential"`
+	Client     string            `json:"client"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Status     jobStatus         `json:"status"`
+	ExitCode   *int              `json:"exit_code"`
+	Stdout     string            `json:"stdout"`
+	Stderr     string            `json:"stderr"`
+	Truncated  bool              `json:"truncated"`
+	Error      string            `json:"error,omitempty"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Duration   string            `json:"duration"`
+}
+
+// trigger describes who started an execution
+type trigger struct {
+	credential string
+	client     string
+	parameters []environment
+	jobID      *uuid.UUID
+}
+
+func newExecution(scriptToRun script, t trigger, result executionResult, err error) execution {
+	result = scriptToRun.visibleResult(result)
+	stdout := newTruncatingBuffer(historyOutputBytes, keepOutputBoth)
+	stderr := newTruncatingBuffer(historyOutputBytes, keepOutputBoth)
+	_, _ = stdout.Write(result.Stdout)
+	_, _ = stderr.Write(result.Stderr)
+
+	e := execution{
+		ID:         uuid.Must(uuid.NewV7()),
+		ScriptID:   scriptToRun.ID,
+		ScriptName: scriptToRun.Name,
+		JobID:      t.jobID,
+		Credential: t.credential,
+		Client:     t.client,
+		Status:     jobSucceeded,
+		Stdout:     string(stdout.Bytes()),
+		Stderr:     string(stderr.Bytes()),
+		Truncated:  result.Truncated || stdout.isTruncated() || stderr.isTruncated(),
+		StartedAt:  result.StartedAt,
+		Duration:   result.Duration.String(),
+	}
+	if e.StartedAt.IsZero() {
+		e.StartedAt = time.Now()
+	}
+	e.FinishedAt = e.StartedAt.Add(result.Duration)
+	if len(t.parameters) > 0 {
+		e.Parameters = make(map[string]string)
+		for _, p := range t.parameters {
+			e.Parameters[p.Key] = p.Value
+		}
+	}
+	if result.ExitCode >= 0 {
+		exitCode := result.ExitCode
+		e.ExitCode = &exitCode
+	}
+	if err != nil {
+		e.Status = jobFailed
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// historyStore keeps the executions in a bbolt database, in a bucket per script. Execution IDs are
+// UUIDv7, so the keys of a bucket are sorted by start time.
+type historyStore struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+func openHistory(path string, retention time.Duration) (*historyStore, error) {
+	if retention <= 0 {
+		retention = defaultHistoryRetention
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening history %s: %v", path, err)
+	}
+	return &historyStore{db: db, retention: retention}, nil
+}
+
+func (h *historyStore) close() error {
+	return h.db.Close()
+}
+
+// record stores the execution and forgets the ones of the same script older than the retention period.
+// Failing to record an execution doesn't fail the request, it is only logged.
+func (h *historyStore) record(e execution) {
+	if h == nil {
+		return
+	}
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(e.ScriptID.String()))
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(e.ID[:], value); err != nil {
+			return err
+		}
+		return h.prune(bucket)
+	})
+	if err != nil {
+		log.WithFields(log.Fields{"ID": e.ScriptID, "Execution": e.ID}).Errorf("error recording execution %v", err)
+		errorsTotal.Inc()
+	}
+}
+
+// prune must be called within a writable transaction
+func (h *historyStore) prune(bucket *bolt.Bucket) error {
+	cutoff := time.Now().Add(-h.retention)
+	cursor := bucket.Cursor()
+	for key, _ := cursor.First(); key != nil; key, _ = cursor.First() {
+		id, err := uuid.FromBytes(key)
+		if err != nil || time.Unix(id.Time().UnixTime()).After(cutoff) {
+			return nil
+		}
+		if err := cursor.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *historyStore) get(id uuid.UUID) (execution, bool, error) {
+	var e execution
+	found := false
+	err := h.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(_ []byte, bucket *bolt.Bucket) error {
+			if value := bucket.Get(id[:]); value != nil {
+				found = true
+				return json.Unmarshal(value, &e)
+			}
+			return nil
+		})
+	})
+	return e, found, err
+}
+
+// executionFilter selects the executions returned by list
+type executionFilter struct {
+	scriptID   uuid.UUID
+	credential string
+	client     string
+	status     jobStatus
+	exitCode   *int
+	since      time.Time
+	until      time.Time
+	// before is the ID of the last execution of the previous page
+	before *uuid.UUID
+	limit  int
+}
+
+func (f executionFilter) matches(e execution) bool {
+	switch {
+	case f.credential != "" && e.Credential != f.credential:
+		return false
+	case f.client != "" && e.Client != f.client:
+		return false
+	case f.status != "" && e.Status != f.status:
+		return false
+	case f.exitCode != nil && (e.ExitCode == nil || *e.ExitCode != *f.exitCode):
+		return false
+	case !f.since.IsZero() && e.StartedAt.Before(f.since):
+		return false
+	case !f.until.IsZero() && e.StartedAt.After(f.until):
+		return false
+	}
+	return true
+}
+
+// list returns the executions of a script that match the filter, the newest first, and the ID to
+// pass as before to get the next page, if there is one
+func (h *historyStore) list(f executionFilter) ([]execution, *uuid.UUID, error) {
+	executions := []execution{}
+	var next *uuid.UUID
+	err := h.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(f.scriptID.String()))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		key, value := cursor.Last()
+		if f.before != nil {
+			key, value = cursor.Seek(f.before[:])
+			if key == nil {
+				key, value = cursor.Last()
+			} else {
+				key, value = cursor.Prev()
+			}
+		}
+		for ; key != nil; key, value = cursor.Prev() {
+			var e execution
+			if err := json.Unmarshal(value, &e); err != nil {
+				return err
+			}
+			if !f.matches(e) {
+				continue
+			}
+			if len(executions) == f.limit {
+				last := executions[len(executions)-1].ID
+				next = &last
+				return nil
+			}
+			executions = append(executions, e)
+		}
+		return nil
+	})
+	return executions, next, err
+}
+
+// getExecutionFilter reads the filter for the executions of a script from the query string
+func getExecutionFilter(query url.Values, scriptID uuid.UUID) (executionFilter, error) {
+	f := executionFilter{
+		scriptID:   scriptID,
+		credential: query.Get("credential"),
+		client:     query.Get("client"),
+		status:     jobStatus(query.Get("status")),
+		limit:      defaultHistoryLimit,
+	}
+	var err error
+	if value := query.Get("exit_code"); value != "" {
+		exitCode, err := strconv.Atoi(value)
+		if err != nil {
+			return f, fmt.Errorf("invalid exit_code: %s", value)
+		}
+		f.exitCode = &exitCode
+	}
+	if value := query.Get("since"); value != "" {
+		if f.since, err = time.Parse(time.RFC3339, value); err != nil {
+			return f, fmt.Errorf("invalid since: %s", value)
+		}
+	}
+	if value := query.Get("until"); value != "" {
+		if f.until, err = time.Parse(time.RFC3339, value); err != nil {
+			return f, fmt.Errorf("invalid until: %s", value)
+		}
+	}
+	if value := query.Get("before"); value != "" {
+		before, err := uuid.Parse(value)
+		if err != nil {
+			return f, fmt.Errorf("invalid before: %s", value)
+		}
+		f.before = &before
+	}
+	if value := query.Get("limit"); value != "" {
+		if f.limit, err = strconv.Atoi(value); err != nil || f.limit < 1 || f.limit > maxHistoryLimit {
+			return f, fmt.Errorf("invalid limit: %s, use a number from 1 to %d", value, maxHistoryLimit)
+		}
+	}
+	return f, nil
+}
+
+// authorizeHistory checks that the client can call scriptToRun, which is what allows it to see its executions.
+// Like the calls to the script, the client rate limit is checked before the token.
+func (s *server) authorizeHistory(w http.ResponseWriter, r *http.Request, scriptToRun script, c configuration) bool {
+	remoteIP := getRemoteIP(r, c.TrustedProxies)
+	cliErr := checkNetwork(remoteIP, scriptToRun, c)
+	if cliErr == nil {
+		if cliErr = s.limiter.check(keyedRateLimit{"client:" + remoteIP, c.ClientRateLimit}); cliErr != nil {
+			reportRateLimited(w, cliErr, scriptToRun, remoteIP)
+			return false
+		}
+		_, cliErr = checkAuthorization(r, nil, scriptToRun, c)
+	}
+	if cliErr != nil {
+		log.WithFields(log.Fields{
+			"Error":  cliErr.Message,
+			"Client": remoteIP,
+		}).Warning("Authorization error")
+		http.Error(w, cliErr.Message, cliErr.HTTPCode)
+		return false
+	}
+	return true
+}
+
+func executionsHandler(s *server) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.history == nil {
+			http.Error(w, "execution history is disabled", http.StatusNotFound)
+			return
+		}
+		c, _ := s.current()
+		// The script is required, so the request can be authorized like the calls to it
+		scriptToRun, err := c.getHook(r.URL.Query().Get("script"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !s.authorizeHistory(w, r, scriptToRun, c) {
+			return
+		}
+		f, err := getExecutionFilter(r.URL.Query(), scriptToRun.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		executions, next, err := s.history.list(f)
+		if err != nil {
+			reportError(err, w)
+			return
+		}
+		response := struct {
+			Executions []execution `json:"executions"`
+			Next       *uuid.UUID  `json:"next,omitempty"`
+		}{executions, next}
+		respondJSON(w, http.StatusOK, response)
+	}
+}
+
+func executionHistoryHandler(s *server) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.history == nil {
+			http.Error(w, "execution history is disabled", http.StatusNotFound)
+			return
+		}
+		c, _ := s.current()
+		id, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid execution ID: %s", r.PathValue("id")), http.StatusBadRequest)
+			return
+		}
+
+		e, found, err := s.history.get(id)
+		if err != nil {
+			reportError(err, w)
+			return
+		}
+		if !found {
+			http.Error(w, fmt.Sprintf("execution not found: %s", id), http.StatusNotFound)
+			return
+		}
+
+		scriptToRun, err := c.getScript(e.ScriptID.String())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("execution not found: %s", id), http.StatusNotFound)
+			return
+		}
+		if !s.authorizeHistory(w, r, scriptToRun, c) {
+			return
+		}
+		respondJSON(w, http.StatusOK, e)
+	}
+}
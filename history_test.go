@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHistory(t *testing.T, retention time.Duration) *historyStore {
+	h, err := openHistory(filepath.Join(t.TempDir(), "history.db"), retention)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.close() })
+	return h
+}
+
+func TestNewExecution(t *testing.T) {
+	scriptToRun := script{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Name: "deploy", ReturnStderr: true}
+	startedAt := time.Now()
+	result := executionResult{Stdout: []byte("out"), Stderr: []byte("err"), ExitCode: 3, StartedAt: startedAt, Duration: time.Second}
+	t1 := trigger{credential: "ci", client: "192.0.2.1", parameters: []environment{{Key: "NAME", Value: "Sam"}}}
+
+	e := newExecution(scriptToRun, t1, result, errors.New("exit status 3"))
+	assert.Equal(t, scriptToRun.ID, e.ScriptID)
+	assert.Equal(t, "deploy", e.ScriptName)
+	assert.Equal(t, "ci", e.Credential)
+	assert.Equal(t, "192.0.2.1", e.Client)
+	assert.Equal(t, map[string]string{"NAME": "Sam"}, e.Parameters)
+	assert.Equal(t, jobFailed, e.Status)
+	require.NotNil(t, e.ExitCode)
+	assert.Equal(t, 3, *e.ExitCode)
+	assert.Equal(t, "out", e.Stdout)
+	assert.Equal(t, "err", e.Stderr)
+	assert.Equal(t, "exit status 3", e.Error)
+	assert.Equal(t, startedAt.Add(time.Second), e.FinishedAt)
+
+	scriptToRun.ReturnStderr = false
+	e = newExecution(scriptToRun, t1, result, errors.New("exit status 3"))
+	assert.Equal(t, "out", e.Stdout)
+	assert.Empty(t, e.Stderr, "stderr should only be recorded with return_stderr")
+
+	scriptToRun.HideOutput = true
+	e = newExecution(scriptToRun, t1, result, nil)
+	assert.Equal(t, jobSucceeded, e.Status)
+	assert.Empty(t, e.Stdout)
+	assert.Empty(t, e.Stderr)
+}
+
+func TestHistoryList(t *testing.T) {
+	h := newTestHistory(t, 0)
+	scriptID := parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	otherID := parseUUIDOrPanic("b9f71a96-0d23-11ee-860e-ff55b106c448")
+
+	var ids []uuid.UUID
+	for i := range 5 {
+		credential := "ci"
+		if i%2 == 1 {
+			credential = "cron"
+		}
+		e := newExecution(script{ID: scriptID}, trigger{credential: credential}, executionResult{ExitCode: i}, nil)
+		h.record(e)
+		ids = append(ids, e.ID)
+	}
+	h.record(newExecution(script{ID: otherID}, trigger{credential: "ci"}, executionResult{}, nil))
+
+	executions, next, err := h.list(executionFilter{scriptID: scriptID, limit: 2})
+	require.NoError(t, err)
+	require.Len(t, executions, 2)
+	assert.Equal(t, ids[4], executions[0].ID, "the newest execution should be first")
+	assert.Equal(t, ids[3], executions[1].ID)
+	require.NotNil(t, next)
+
+	executions, next, err = h.list(executionFilter{scriptID: scriptID, limit: 2, before: next})
+	require.NoError(t, err)
+	require.Len(t, executions, 2)
+	assert.Equal(t, ids[2], executions[0].ID)
+	assert.Equal(t, ids[1], executions[1].ID)
+
+	executions, next, err = h.list(executionFilter{scriptID: scriptID, limit: 2, before: next})
+	require.NoError(t, err)
+	require.Len(t, executions, 1)
+	assert.Equal(t, ids[0], executions[0].ID)
+	assert.Nil(t, next)
+
+	executions, _, err = h.list(executionFilter{scriptID: scriptID, credential: "cron", limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, executions, 2)
+
+	exitCode := 2
+	executions, _, err = h.list(executionFilter{scriptID: scriptID, exitCode: &exitCode, limit: 10})
+	require.NoError(t, err)
+	require.Len(t, executions, 1)
+	assert.Equal(t, ids[2], executions[0].ID)
+
+	executions, _, err = h.list(executionFilter{scriptID: parseUUIDOrPanic("5e5adb92-0d04-11ee-97cf-4b6c30e50f6a"), limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, executions)
+
+	e, found, err := h.get(ids[1])
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "cron", e.Credential)
+
+	_, found, err = h.get(uuid.New())
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestHistoryRetention(t *testing.T) {
+	h := newTestHistory(t, 50*time.Millisecond)
+	scriptToRun := script{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")}
+
+	old := newExecution(scriptToRun, trigger{}, executionResult{}, nil)
+	h.record(old)
+	time.Sleep(100 * time.Millisecond)
+	recent := newExecution(scriptToRun, trigger{}, executionResult{}, nil)
+	h.record(recent)
+
+	executions, _, err := h.list(executionFilter{scriptID: scriptToRun.ID, limit: 10})
+	require.NoError(t, err)
+	require.Len(t, executions, 1)
+	assert.Equal(t, recent.ID, executions[0].ID)
+}
+
+func TestExecutionsEndpoints(t *testing.T) {
+	scriptID := parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	srv := newServer(configuration{
+		DefaultTokens: []credential{{Name: "ci", Token: "test"}},
+		Scripts: []script{
+			{ID: scriptID, Name: "deploy", Inline: "echo \"deploying $VERSION\"", Parameters: []parameter{{Name: "VERSION"}}},
+			{ID: parseUUIDOrPanic("b9f71a96-0d23-11ee-860e-ff55b106c448"), Inline: "echo other", Token: "other"},
+		},
+	})
+	srv.history = newTestHistory(t, 0)
+	call := func(url string, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", token)
+		req.RemoteAddr = "192.0.2.1:41234"
+		rr := httptest.NewRecorder()
+		srv.router().ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusOK, call("/hooks/deploy?VERSION=1.2.3", "test").Code)
+
+	rr := call("/executions?script=deploy", "test")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var page struct {
+		Executions []execution `json:"executions"`
+		Next       *uuid.UUID  `json:"next"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	require.Len(t, page.Executions, 1)
+	e := page.Executions[0]
+	assert.Equal(t, "ci", e.Credential)
+	assert.Equal(t, "192.0.2.1", e.Client)
+	assert.Equal(t, map[string]string{"VERSION": "1.2.3"}, e.Parameters)
+	assert.Equal(t, "deploying 1.2.3\n", e.Stdout)
+	assert.Nil(t, page.Next)
+
+	rr = call("/executions/"+e.ID.String(), "test")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), e.ID.String())
+
+	tests := []struct {
+		name         string
+		url          string
+		token        string
+		expectedCode int
+	}{
+		{"When the token is not valid for the script then the list should not be returned", "/executions?script=deploy", "other", http.StatusUnauthorized},
+		{"When the token is not valid for the script then the execution should not be returned", "/executions/" + e.ID.String(), "other", http.StatusUnauthorized},
+		{"When the script is missing then it should return 400", "/executions", "test", http.StatusBadRequest},
+		{"When a filter is not valid then it should return 400", "/executions?script=deploy&limit=0", "test", http.StatusBadRequest},
+		{"When the execution doesn't exist then it should return 404", "/executions/" + uuid.NewString(), "test", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expectedCode, call(test.url, test.token).Code)
+		})
+	}
+}
+
+func TestExecutionsEndpointWithoutHistory(t *testing.T) {
+	router := getRouter(configuration{DefaultToken: "test"})
+	req, _ := http.NewRequest("GET", "/executions?script=deploy", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestExecutionsEndpointsCheckTheClientRateLimitBeforeTheToken(t *testing.T) {
+	srv := newServer(configuration{
+		DefaultToken:    "test",
+		ClientRateLimit: rateLimit{Requests: 1, Interval: time.Minute},
+		Scripts:         []script{{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Name: "deploy", Inline: "echo ok"}},
+	})
+	srv.history = newTestHistory(t, 0)
+	call := func(token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/executions?script=deploy", nil)
+		req.Header.Set("Authorization", token)
+		req.RemoteAddr = "192.0.2.1:41234"
+		rr := httptest.NewRecorder()
+		srv.router().ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, call("guess").Code)
+	rr := call("another guess")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultJobRetention = time.Hour
+
+type jobStatus string
+
+const (
+	jobQueued    jobStatus = "queued"
+	jobRunning   jobStatus = "running"
+	jobSucceeded jobStatus = "succeeded"
+	jobFailed    jobStatus = "failed"
+)
+
+type job struct {
+	ID         uuid.UUID  `json:"id"`
+	ScriptID   uuid.UUID  `json:"script_id"`
+	Status     jobStatus  `json:"status"`
+	ExitCode   *int       `json:"exit_code,omitempty"`
+	Stdout     string     `json:"stdout"`
+	Stderr     string     `json:"stderr"`
+	Truncated  bool       `json:"truncated"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Duration   string     `json:"duration,omitempty"`
+}
+
+func (j job) isFinished() bool {
+	return j.Status == jobSucceeded || j.Status == jobFailed
+}
+
+// jobStore keeps the state of asynchronous executions in memory. Finished jobs are
+// forgotten once they are older than the retention period.
+type jobStore struct {
+	mu        sync.RWMutex
+	jobs      map[uuid.UUID]*job
+	retention time.Duration
+}
+
+func newJobStore(retention time.Duration) *jobStore {
+	if retention <= 0 {
+		retention = defaultJobRetention
+	}
+	return &jobStore{jobs: make(map[uuid.UUID]*job), retention: retention}
+}
+
+func (s *jobStore) setRetention(retention time.Duration) {
+	if retention <= 0 {
+		retention = defaultJobRetention
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retention = retention
+}
+
+func (s *jobStore) create(scriptID uuid.UUID) job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+
+	j := &job{ID: uuid.New(), ScriptID: scriptID, Status: jobQueued, CreatedAt: time.Now()}
+	s.jobs[j.ID] = j
+	return *j
+}
+
+func (s *jobStore) get(id uuid.UUID) (job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+func (s *jobStore) start(id uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[id]; ok {
+		now := time.Now()
+		j.Status = jobRunning
+		j.StartedAt = &now
+	}
+}
+
+func (s *jobStore) finish(id uuid.UUID, result executionResult, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	j.FinishedAt = &now
+	j.Stdout = string(result.Stdout)
+	j.Stderr = string(result.Stderr)
+	j.Truncated = result.Truncated
+	j.Duration = result.Duration.String()
+	if result.ExitCode >= 0 {
+		exitCode := result.ExitCode
+		j.ExitCode = &exitCode
+	}
+	j.Status = jobSucceeded
+	if err != nil {
+		j.Status = jobFailed
+		j.Error = err.Error()
+	}
+}
+
+// prune must be called with the lock held
+func (s *jobStore) prune() {
+	for id, j := range s.jobs {
+		if j.isFinished() && time.Since(*j.FinishedAt) > s.retention {
+			delete(s.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobStoreLifecycle(t *testing.T) {
+	store := newJobStore(time.Hour)
+	scriptID := uuid.New()
+
+	j := store.create(scriptID)
+	assert.Equal(t, jobQueued, j.Status)
+
+	store.start(j.ID)
+	j, _ = store.get(j.ID)
+	assert.Equal(t, jobRunning, j.Status)
+	assert.NotNil(t, j.StartedAt)
+
+	store.finish(j.ID, executionResult{Stdout: []byte("out"), Stderr: []byte("err"), ExitCode: 2}, errors.New("exit status 2"))
+	j, _ = store.get(j.ID)
+	assert.Equal(t, jobFailed, j.Status)
+	assert.Equal(t, 2, *j.ExitCode)
+	assert.Equal(t, "out", j.Stdout)
+	assert.Equal(t, "err", j.Stderr)
+	assert.Equal(t, "exit status 2", j.Error)
+}
+
+func TestJobStorePrunesFinishedJobs(t *testing.T) {
+	store := newJobStore(time.Millisecond)
+
+	finished := store.create(uuid.New())
+	store.finish(finished.ID, executionResult{}, nil)
+	running := store.create(uuid.New())
+	store.start(running.ID)
+
+	time.Sleep(5 * time.Millisecond)
+	store.create(uuid.New())
+
+	_, found := store.get(finished.ID)
+	assert.False(t, found)
+	_, found = store.get(running.ID)
+	assert.True(t, found)
+}
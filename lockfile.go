@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+const lockFilePollInterval = 100 * time.Millisecond
+
+// tryLockFile takes an exclusive flock on path, creating the file if needed. It fails with errScriptBusy
+// if another process, or another execution of this one, holds the lock.
+func tryLockFile(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file %v", err)
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errScriptBusy
+		}
+		return nil, fmt.Errorf("error locking %s %v", path, err)
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// lockFile waits until it can take the lock on path or ctx is done
+func lockFile(ctx context.Context, path string) (func(), error) {
+	ticker := time.NewTicker(lockFilePollInterval)
+	defer ticker.Stop()
+	for {
+		unlock, err := tryLockFile(path)
+		if !errors.Is(err, errScriptBusy) {
+			return unlock, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTryLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.lock")
+
+	unlock, err := tryLockFile(path)
+	require.NoError(t, err)
+	_, err = tryLockFile(path)
+	assert.ErrorIs(t, err, errScriptBusy)
+
+	unlock()
+	unlock, err = tryLockFile(path)
+	assert.NoError(t, err)
+	unlock()
+
+	_, err = tryLockFile(filepath.Join(t.TempDir(), "missing", "script.lock"))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errScriptBusy)
+}
+
+func TestLockFileWaits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.lock")
+	unlock, err := tryLockFile(path)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	_, err = lockFile(ctx, path)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	time.AfterFunc(50*time.Millisecond, unlock)
+	unlockAgain, err := lockFile(context.Background(), path)
+	require.NoError(t, err)
+	unlockAgain()
+}
+
+func TestLockFileOfScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.lock")
+	scriptID := parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	srv := newServer(configuration{DefaultToken: "test", Scripts: []script{{ID: scriptID, Inline: "echo ok", LockFile: path, WhenBusy: whenBusyReject}}})
+	callHook := func() int {
+		req, _ := http.NewRequest("GET", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", nil)
+		req.Header.Set("Authorization", "test")
+		rr := httptest.NewRecorder()
+		srv.router().ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	unlock, err := tryLockFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, callHook(), "the lock held by someone else should make the script busy")
+
+	unlock()
+	assert.Equal(t, http.StatusOK, callHook())
+}
+
+func TestLockFileIsOnlyValidForNonConcurrentScripts(t *testing.T) {
+	assert.True(t, script{Inline: "echo ok", LockFile: "/tmp/script.lock"}.isValid())
+	assert.False(t, script{Inline: "echo ok", LockFile: "/tmp/script.lock", Concurrent: true}.isValid())
+	assert.False(t, script{Inline: "echo ok", LockFile: "/tmp/script.lock", MaxConcurrency: 2}.isValid())
+}
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/term"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var (
@@ -17,9 +25,25 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "hash-token":
+			if err := hashTokenCommand(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "validate":
+			if err := validateCommand(os.Args[2:], os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	var port int
-	var configFile, logLevel, certFile, keyFile string
+	var configFile, logLevel, certFile, keyFile, historyFile string
 	var version bool
+	var watchInterval, gracePeriod, historyRetention time.Duration
 
 	flag.IntVar(&port, "port", 9081, "Port to listen on")
 	flag.StringVar(&configFile, "config", "./config.yaml", "Path to config file (optional)")
@@ -27,6 +51,10 @@ func main() {
 	flag.StringVar(&certFile, "cert", "", "Path to TLS certificate file (optional)")
 	flag.StringVar(&keyFile, "key", "", "Path to TLS key file (optional)")
 	flag.BoolVar(&version, "version", false, "prints version and exits")
+	flag.DurationVar(&gracePeriod, "grace-period", 30*time.Second, "How long to wait for running scripts to finish when shutting down before terminating them")
+	flag.DurationVar(&watchInterval, "watch", 0, "Reload the config file when it changes, checking it with this interval (e.g. 5s). The config is always reloaded on SIGHUP")
+	flag.StringVar(&historyFile, "history", "", "Path to the database where every execution is recorded, enabling /executions (optional)")
+	flag.DurationVar(&historyRetention, "history-retention", defaultHistoryRetention, "How long executions are kept in the history")
 	flag.Parse()
 
 	err := configureLogs(logLevel)
@@ -54,24 +82,126 @@ func main() {
 		log.Fatal("Both cert and key must be provided together or left empty.")
 	}
 
-	router := getRouter(c)
-	if certFile != "" && keyFile != "" {
-		log.WithFields(log.Fields{
-			"port": port,
-			"cert": certFile,
-			"key":  keyFile,
-		}).Info("Starting TLS server")
-		if err := http.ListenAndServeTLS(fmt.Sprintf(":%d", port), certFile, keyFile, router); err != nil {
-			log.Fatalf("Error starting TLS server: %v", err)
+	srv := newServer(c)
+	if historyFile != "" {
+		srv.history, err = openHistory(historyFile, historyRetention)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	reloadOnSIGHUP(srv, configFile)
+	if watchInterval > 0 {
+		go srv.watchConfig(configFile, watchInterval)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	httpServer := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: srv.router()}
+	go func() {
+		if certFile != "" && keyFile != "" {
+			log.WithFields(log.Fields{
+				"port": port,
+				"cert": certFile,
+				"key":  keyFile,
+			}).Info("Starting TLS server")
+			if err := httpServer.ListenAndServeTLS(certFile, keyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Error starting TLS server: %v", err)
+			}
+		} else {
+			log.WithFields(log.Fields{
+				"port": port,
+			}).Info("Starting server")
+			if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Error starting server: %v", err)
+			}
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	gracefulShutdown(httpServer, srv, gracePeriod)
+	if srv.history != nil {
+		if err := srv.history.close(); err != nil {
+			log.Errorf("Error closing history: %v", err)
 		}
-	} else {
-		log.WithFields(log.Fields{
-			"port": port,
-		}).Info("Starting server")
-		if err := http.ListenAndServe(fmt.Sprintf(":%d", port), router); err != nil {
-			log.Fatalf("Error starting server: %v", err)
+	}
+}
+
+func reloadOnSIGHUP(srv *server, configFile string) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			log.WithFields(log.Fields{"config": configFile}).Info("SIGHUP received, reloading configuration")
+			srv.reloadFromFile(configFile)
+		}
+	}()
+}
+
+// validateCommand checks the config file and prints every problem found in it
+func validateCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configFile := flags.String("config", "./config.yaml", "Path to config file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	problems := validateConfig(*configFile)
+	for _, problem := range problems {
+		_, _ = fmt.Fprintln(stdout, problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s has %d problem(s)", *configFile, len(problems))
+	}
+	_, err := fmt.Fprintf(stdout, "%s is valid\n", *configFile)
+	return err
+}
+
+// hashTokenCommand prints the hash of a token, read from the arguments or from the standard input
+func hashTokenCommand(args []string, stdin *os.File, stdout io.Writer) error {
+	flags := flag.NewFlagSet("hash-token", flag.ExitOnError)
+	algorithm := flags.String("algorithm", hashAlgorithmBcrypt, fmt.Sprintf("Hash algorithm (%s)", strings.Join(hashAlgorithms, ", ")))
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), "Usage: %s hash-token [-algorithm name] [token]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	token := flags.Arg(0)
+	if token == "" {
+		var err error
+		token, err = readToken(stdin)
+		if err != nil {
+			return err
 		}
 	}
+	if token == "" {
+		return fmt.Errorf("the token can't be empty")
+	}
+
+	hash, err := hashToken(token, *algorithm)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(stdout, hash)
+	return err
+}
+
+func readToken(stdin *os.File) (string, error) {
+	if term.IsTerminal(int(stdin.Fd())) {
+		_, _ = fmt.Fprint(os.Stderr, "Token: ")
+		token, err := term.ReadPassword(int(stdin.Fd()))
+		_, _ = fmt.Fprintln(os.Stderr)
+		return string(token), err
+	}
+	token, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(token, "\r\n"), nil
 }
 
 func configureLogs(logLevel string) error {
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var httpMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+func areValidMethods(methods []string) bool {
+	for _, method := range methods {
+		if !slices.Contains(httpMethods, strings.ToUpper(method)) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowedMethods returns the methods a script can be called with. The script's own list takes
+// precedence over the global one, and when neither is set every method is allowed.
+func (s script) allowedMethods(c configuration) []string {
+	methods := s.Methods
+	if len(methods) == 0 {
+		methods = c.Methods
+	}
+	allowed := make([]string, len(methods))
+	for i, method := range methods {
+		allowed[i] = strings.ToUpper(method)
+	}
+	return allowed
+}
+
+func checkMethod(r *http.Request, scriptToRun script, c configuration) (string, *ClientError) {
+	allowed := scriptToRun.allowedMethods(c)
+	if len(allowed) == 0 || slices.Contains(allowed, r.Method) {
+		return "", nil
+	}
+	return strings.Join(allowed, ", "), &ClientError{Message: "Method not allowed", HTTPCode: http.StatusMethodNotAllowed}
+}
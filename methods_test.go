@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowedMethods(t *testing.T) {
+	scriptID := parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	tests := []struct {
+		name          string
+		configuration configuration
+		method        string
+		expectedCode  int
+		expectedAllow string
+	}{
+		{
+			"When no methods are configured then any method should be accepted",
+			configuration{DefaultToken: "test", Scripts: []script{{ID: scriptID, Inline: "echo ok"}}},
+			http.MethodGet,
+			http.StatusOK,
+			"",
+		},
+		{
+			"When the script only allows POST then GET should be rejected",
+			configuration{DefaultToken: "test", Scripts: []script{{ID: scriptID, Inline: "echo ok", Methods: []string{"post"}}}},
+			http.MethodGet,
+			http.StatusMethodNotAllowed,
+			"POST",
+		},
+		{
+			"When the script only allows POST then POST should be accepted",
+			configuration{DefaultToken: "test", Scripts: []script{{ID: scriptID, Inline: "echo ok", Methods: []string{"post"}}}},
+			http.MethodPost,
+			http.StatusOK,
+			"",
+		},
+		{
+			"When the global setting only allows POST then GET should be rejected",
+			configuration{DefaultToken: "test", Methods: []string{"POST"}, Scripts: []script{{ID: scriptID, Inline: "echo ok"}}},
+			http.MethodGet,
+			http.StatusMethodNotAllowed,
+			"POST",
+		},
+		{
+			"When the script allows more methods than the global setting then the script should take precedence",
+			configuration{DefaultToken: "test", Methods: []string{"POST"}, Scripts: []script{{ID: scriptID, Inline: "echo ok", Methods: []string{"GET", "POST"}}}},
+			http.MethodPut,
+			http.StatusMethodNotAllowed,
+			"GET, POST",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := getRouter(test.configuration)
+			req, _ := http.NewRequest(test.method, "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", nil)
+			req.Header.Set("Authorization", "test")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, test.expectedCode, rr.Code)
+			assert.Equal(t, test.expectedAllow, rr.Header().Get("Allow"))
+		})
+	}
+}
+
+func TestAreValidMethods(t *testing.T) {
+	assert.True(t, areValidMethods([]string{"GET", "post"}))
+	assert.False(t, areValidMethods([]string{"FETCH"}))
+}
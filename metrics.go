@@ -14,6 +14,18 @@ var (
 		Name: "shellhook_execs_total",
 		Help: "The total number of calls to exec",
 	})
+	timeoutsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "shellhook_timeouts_total",
+		Help: "The total number of script executions aborted because of a timeout",
+	})
+	rateLimitedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "shellhook_rate_limited_total",
+		Help: "The total number of requests rejected because of a rate limit",
+	})
+	queueFullTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "shellhook_queue_full_total",
+		Help: "The total number of requests rejected because too many executions of the script were waiting",
+	})
 	execDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "shellhook_exec_duration_seconds",
 		Help:    "Script execution duration in seconds",
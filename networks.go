@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseNetwork parses a CIDR range or a single IP address
+func parseNetwork(network string) (netip.Prefix, error) {
+	if !strings.Contains(network, "/") {
+		addr, err := netip.ParseAddr(network)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(network)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+func areValidNetworks(networks []string) bool {
+	for _, network := range networks {
+		if _, err := parseNetwork(network); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAddr(networks []string, addr netip.Addr) bool {
+	for _, network := range networks {
+		prefix, err := parseNetwork(network)
+		if err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNetwork rejects clients in any of the denied networks, global or of the script, and clients outside
+// the allowed networks. The script's allowed networks take precedence over the global ones.
+func checkNetwork(remoteIP string, scriptToRun script, c configuration) *ClientError {
+	allowed := scriptToRun.AllowedNetworks
+	if len(allowed) == 0 {
+		allowed = c.AllowedNetworks
+	}
+	if len(allowed) == 0 && len(c.DeniedNetworks) == 0 && len(scriptToRun.DeniedNetworks) == 0 {
+		return nil
+	}
+
+	addr, err := netip.ParseAddr(remoteIP)
+	if err != nil {
+		return &ClientError{Message: "Forbidden", HTTPCode: http.StatusForbidden}
+	}
+	addr = addr.Unmap()
+
+	if containsAddr(c.DeniedNetworks, addr) || containsAddr(scriptToRun.DeniedNetworks, addr) {
+		return &ClientError{Message: "Forbidden", HTTPCode: http.StatusForbidden}
+	}
+	if len(allowed) > 0 && !containsAddr(allowed, addr) {
+		return &ClientError{Message: "Forbidden", HTTPCode: http.StatusForbidden}
+	}
+	return nil
+}
+
+// getRemoteIP returns the address of the client. The headers set by proxies are only used when the request
+// comes from one of the trusted proxies, and only the addresses they added are believed: the chain is walked
+// from the closest hop and the first address that is not a trusted proxy is the client.
+func getRemoteIP(r *http.Request, trustedProxies []string) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	if !isTrustedProxy(peer, trustedProxies) {
+		return peer
+	}
+
+	chain := getForwardedChain(r)
+	if len(chain) == 0 {
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+			return realIP
+		}
+		return peer
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if !isTrustedProxy(chain[i], trustedProxies) {
+			return chain[i]
+		}
+	}
+	return chain[0]
+}
+
+func isTrustedProxy(ip string, trustedProxies []string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && containsAddr(trustedProxies, addr.Unmap())
+}
+
+// getForwardedChain returns the client addresses recorded by proxies, from the original client to the
+// closest proxy, taken from the Forwarded header or, if it has no for parameter, from X-Forwarded-For
+func getForwardedChain(r *http.Request) []string {
+	var chain []string
+	for _, header := range r.Header.Values("Forwarded") {
+		for _, element := range strings.Split(header, ",") {
+			if forwardedFor := getForwardedFor(element); forwardedFor != "" {
+				chain = append(chain, forwardedFor)
+			}
+		}
+	}
+	if len(chain) > 0 {
+		return chain
+	}
+
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = stripPort(strings.TrimSpace(hop)); hop != "" {
+				chain = append(chain, hop)
+			}
+		}
+	}
+	return chain
+}
+
+// getForwardedFor extracts the address in the for parameter of an RFC 7239 forwarded-element, or returns
+// an empty string if it has none. Obfuscated or unknown identifiers are returned as they are and never
+// match a network.
+func getForwardedFor(element string) string {
+	for _, pair := range strings.Split(element, ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if found && strings.EqualFold(key, "for") {
+			return stripPort(strings.Trim(value, `"`))
+		}
+	}
+	return ""
+}
+
+func stripPort(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+}
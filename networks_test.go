@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckNetwork(t *testing.T) {
+	tests := []struct {
+		name          string
+		remoteIP      string
+		script        script
+		configuration configuration
+		expectedCode  int
+	}{
+		{"When no networks are configured then any client should be allowed", "203.0.113.7", script{}, configuration{}, 0},
+		{"When the client is in the allowed networks then it should be allowed", "10.1.2.3", script{}, configuration{AllowedNetworks: []string{"10.0.0.0/8"}}, 0},
+		{"When the client is outside the allowed networks then it should be forbidden", "203.0.113.7", script{}, configuration{AllowedNetworks: []string{"10.0.0.0/8"}}, http.StatusForbidden},
+		{"When the script allows a network then it should take precedence over the global one", "192.168.1.10", script{AllowedNetworks: []string{"192.168.1.0/24"}}, configuration{AllowedNetworks: []string{"10.0.0.0/8"}}, 0},
+		{"When the client is in the script's allowed networks only then the global ones should not apply", "10.1.2.3", script{AllowedNetworks: []string{"192.168.1.0/24"}}, configuration{AllowedNetworks: []string{"10.0.0.0/8"}}, http.StatusForbidden},
+		{"When the client is a single allowed IP then it should be allowed", "2001:db8::1", script{AllowedNetworks: []string{"2001:db8::1"}}, configuration{}, 0},
+		{"When the client is globally denied then it should be forbidden even if the script allows it", "10.6.6.6", script{AllowedNetworks: []string{"10.0.0.0/8"}}, configuration{DeniedNetworks: []string{"10.6.6.0/24"}}, http.StatusForbidden},
+		{"When the client is denied by the script then it should be forbidden", "10.6.6.6", script{DeniedNetworks: []string{"10.6.6.6"}}, configuration{}, http.StatusForbidden},
+		{"When an IPv4-mapped address is in the allowed networks then it should be allowed", "::ffff:10.1.2.3", script{AllowedNetworks: []string{"10.0.0.0/8"}}, configuration{}, 0},
+		{"When the client address can't be parsed then it should be forbidden", "unknown", script{AllowedNetworks: []string{"10.0.0.0/8"}}, configuration{}, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cliErr := checkNetwork(test.remoteIP, test.script, test.configuration)
+			if test.expectedCode == 0 {
+				assert.Nil(t, cliErr)
+			} else if assert.NotNil(t, cliErr) {
+				assert.Equal(t, test.expectedCode, cliErr.HTTPCode)
+			}
+		})
+	}
+}
+
+func TestNetworksAreCheckedBeforeAuthorization(t *testing.T) {
+	router := getRouter(configuration{DefaultToken: "test", AllowedNetworks: []string{"10.0.0.0/8"}, Scripts: []script{{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Inline: "echo ok"}}})
+	req, _ := http.NewRequest("GET", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", nil)
+	req.RemoteAddr = "203.0.113.7:41234"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Equal(t, "Forbidden\n", rr.Body.String())
+}
+
+func TestAreValidNetworks(t *testing.T) {
+	assert.True(t, areValidNetworks([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"}))
+	assert.False(t, areValidNetworks([]string{"10.0.0.0/33"}))
+	assert.False(t, areValidNetworks([]string{"ci.example.com"}))
+}
+
+func TestGetRemoteIP(t *testing.T) {
+	trustedProxies := []string{"10.0.0.0/8", "2001:db8::/32"}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"When there are no proxy headers then the peer address should be used", "203.0.113.7:41234", nil, "203.0.113.7"},
+		{"When an untrusted client sends X-Forwarded-For then it should be ignored", "203.0.113.7:41234", map[string]string{"X-Forwarded-For": "192.0.2.1"}, "203.0.113.7"},
+		{"When a trusted proxy sends X-Forwarded-For then the client should be taken from it", "10.0.0.2:41234", map[string]string{"X-Forwarded-For": "192.0.2.1"}, "192.0.2.1"},
+		{"When the chain has several proxies then the closest untrusted hop should be the client", "10.0.0.2:41234", map[string]string{"X-Forwarded-For": "198.51.100.66, 192.0.2.1, 10.0.0.3"}, "192.0.2.1"},
+		{"When every hop is a trusted proxy then the first one should be the client", "10.0.0.2:41234", map[string]string{"X-Forwarded-For": "10.0.0.4, 10.0.0.3"}, "10.0.0.4"},
+		{"When a trusted proxy sends X-Real-IP then it should be used", "10.0.0.2:41234", map[string]string{"X-Real-IP": "192.0.2.1"}, "192.0.2.1"},
+		{"When a trusted proxy sends Forwarded then it should take precedence", "10.0.0.2:41234", map[string]string{"Forwarded": `for=198.51.100.66, for="[2001:db8:cafe::17]:4711";proto=https, For=192.0.2.1:8080;by=10.0.0.2`, "X-Forwarded-For": "198.51.100.99"}, "192.0.2.1"},
+		{"When Forwarded contains an IPv6 client then it should be unbracketed", "[2001:db8::2]:41234", map[string]string{"Forwarded": `for="[2001:db9:cafe::17]:4711"`}, "2001:db9:cafe::17"},
+		{"When Forwarded hides the client then the identifier should be returned", "10.0.0.2:41234", map[string]string{"Forwarded": "for=_hidden"}, "_hidden"},
+		{"When Forwarded has no for parameter then X-Forwarded-For should be used", "10.0.0.2:41234", map[string]string{"Forwarded": "proto=https", "X-Forwarded-For": "192.0.2.1"}, "192.0.2.1"},
+		{"When Forwarded has no for parameter then X-Real-IP should be used", "10.0.0.2:41234", map[string]string{"Forwarded": "proto=https", "X-Real-IP": "192.0.2.1"}, "192.0.2.1"},
+		{"When Forwarded has no for parameter then the peer address should be used", "10.0.0.2:41234", map[string]string{"Forwarded": "proto=https;host=example.com"}, "10.0.0.2"},
+		{"When some Forwarded elements have no for parameter then they should be skipped", "10.0.0.2:41234", map[string]string{"Forwarded": "for=192.0.2.1, proto=https"}, "192.0.2.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/hook", nil)
+			req.RemoteAddr = test.remoteAddr
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+			assert.Equal(t, test.expected, getRemoteIP(req, trustedProxies))
+		})
+	}
+}
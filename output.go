@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+const (
+	keepOutputHead = "head"
+	keepOutputTail = "tail"
+	keepOutputBoth = "both"
+)
+
+var keepOutputModes = []string{keepOutputHead, keepOutputTail, keepOutputBoth}
+
+// truncatingBuffer keeps at most a limited number of bytes of what is written to it: the first ones,
+// the last ones or half of each, so a script that prints too much can't exhaust the memory
+type truncatingBuffer struct {
+	head      []byte
+	headLimit int
+	// tail grows up to twice its limit before the bytes that don't fit are dropped, so they aren't moved on every write
+	tail      []byte
+	tailLimit int
+	truncated bool
+}
+
+// newTruncatingBuffer creates a buffer that keeps limit bytes according to the keep mode, or everything when limit is 0
+func newTruncatingBuffer(limit int64, keep string) *truncatingBuffer {
+	if limit <= 0 {
+		return &truncatingBuffer{headLimit: math.MaxInt}
+	}
+	switch keep {
+	case keepOutputHead:
+		return &truncatingBuffer{headLimit: int(limit)}
+	case keepOutputTail:
+		return &truncatingBuffer{tailLimit: int(limit)}
+	default:
+		return &truncatingBuffer{headLimit: int(limit / 2), tailLimit: int(limit - limit/2)}
+	}
+}
+
+func (b *truncatingBuffer) Write(p []byte) (int, error) {
+	written := len(p)
+	if room := b.headLimit - len(b.head); room > 0 {
+		kept := min(room, len(p))
+		b.head = append(b.head, p[:kept]...)
+		p = p[kept:]
+	}
+	if len(p) == 0 {
+		return written, nil
+	}
+	if len(p) > b.tailLimit {
+		b.truncated = true
+		p = p[len(p)-b.tailLimit:]
+	}
+	b.tail = append(b.tail, p...)
+	if len(b.tail) > 2*b.tailLimit {
+		b.truncated = true
+		copy(b.tail, b.tail[len(b.tail)-b.tailLimit:])
+		b.tail = b.tail[:b.tailLimit]
+	}
+	return written, nil
+}
+
+func (b *truncatingBuffer) Bytes() []byte {
+	tail := b.tail
+	if len(tail) > b.tailLimit {
+		tail = tail[len(tail)-b.tailLimit:]
+	}
+	return slices.Concat(b.head, tail)
+}
+
+func (b *truncatingBuffer) isTruncated() bool {
+	return b.truncated || len(b.tail) > b.tailLimit
+}
+
+func isValidOutputLimit(maxOutputBytes int64, keep string) bool {
+	return maxOutputBytes >= 0 && (keep == "" || slices.Contains(keepOutputModes, keep))
+}
+
+// maxOutputBytes returns the output limit of the script, which overrides the global one
+func (s script) maxOutputBytes(c configuration) int64 {
+	if s.MaxOutputBytes > 0 {
+		return s.MaxOutputBytes
+	}
+	return c.MaxOutputBytes
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncatingBuffer(t *testing.T) {
+	tests := []struct {
+		name              string
+		limit             int64
+		keep              string
+		writes            []string
+		expectedOutput    string
+		expectedTruncated bool
+	}{
+		{"When there is no limit then everything should be kept", 0, "", []string{"abc", "def"}, "abcdef", false},
+		{"When the output fits then nothing should be truncated", 6, keepOutputBoth, []string{"abc", "def"}, "abcdef", false},
+		{"When keeping the head then the first bytes should be kept", 4, keepOutputHead, []string{"abc", "def"}, "abcd", true},
+		{"When keeping the tail then the last bytes should be kept", 4, keepOutputTail, []string{"abc", "def", "ghi"}, "fghi", true},
+		{"When keeping the tail of a single large write then its end should be kept", 4, keepOutputTail, []string{"abcdefghi"}, "fghi", true},
+		{"When keeping both then half of each should be kept", 4, "", []string{"abc", "def", "ghi"}, "abhi", true},
+		{"When keeping both with an odd limit then the tail should get the extra byte", 5, keepOutputBoth, []string{"abcdefghi"}, "abghi", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buffer := newTruncatingBuffer(test.limit, test.keep)
+			for _, write := range test.writes {
+				n, err := buffer.Write([]byte(write))
+				assert.NoError(t, err)
+				assert.Equal(t, len(write), n)
+			}
+			assert.Equal(t, test.expectedOutput, string(buffer.Bytes()))
+			assert.Equal(t, test.expectedTruncated, buffer.isTruncated())
+		})
+	}
+}
+
+func TestTruncatingBufferKeepsMemoryBounded(t *testing.T) {
+	buffer := newTruncatingBuffer(10, keepOutputTail)
+	for range 1000 {
+		_, _ = buffer.Write([]byte(strings.Repeat("x", 7)))
+	}
+	assert.LessOrEqual(t, cap(buffer.tail), 64)
+	assert.Equal(t, strings.Repeat("x", 10), string(buffer.Bytes()))
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	scriptID := parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	tests := []struct {
+		name              string
+		configuration     configuration
+		expectedBody      string
+		expectedTruncated string
+	}{
+		{
+			"When the global limit is exceeded then the output should be truncated",
+			configuration{DefaultToken: "test", MaxOutputBytes: 4, Scripts: []script{{ID: scriptID, Inline: "printf abcdefgh", KeepOutput: keepOutputHead}}},
+			"abcd", "true",
+		},
+		{
+			"When the script has its own limit then it should override the global one",
+			configuration{DefaultToken: "test", MaxOutputBytes: 4, Scripts: []script{{ID: scriptID, Inline: "printf abcdefgh", MaxOutputBytes: 6, KeepOutput: keepOutputTail}}},
+			"cdefgh", "true",
+		},
+		{
+			"When the output fits then it should not be marked as truncated",
+			configuration{DefaultToken: "test", MaxOutputBytes: 100, Scripts: []script{{ID: scriptID, Inline: "printf abcdefgh"}}},
+			"abcdefgh", "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := getRouter(test.configuration)
+			req, _ := http.NewRequest("GET", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", nil)
+			req.Header.Set("Authorization", "test")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, test.expectedBody, rr.Body.String())
+			assert.Equal(t, test.expectedTruncated, rr.Header().Get("X-Output-Truncated"))
+		})
+	}
+}
+
+func TestOutputLimitIsValid(t *testing.T) {
+	assert.True(t, script{Inline: "echo ok", MaxOutputBytes: 1024, KeepOutput: keepOutputTail}.isValid())
+	assert.False(t, script{Inline: "echo ok", MaxOutputBytes: -1}.isValid())
+	assert.False(t, script{Inline: "echo ok", KeepOutput: "middle"}.isValid())
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	parameterNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// reservedParameters are query parameters that shellhook itself understands
+	reservedParameters = []string{"script", "async", "stream"}
+	// parameterPatterns caches the compiled patterns, so they are compiled once and not on every request
+	parameterPatterns sync.Map
+)
+
+type parameter struct {
+	Name     string   `yaml:"name"`
+	Required bool     `yaml:"required"`
+	Default  string   `yaml:"default,omitempty"`
+	Pattern  string   `yaml:"pattern,omitempty"`
+	Enum     []string `yaml:"enum,omitempty"`
+}
+
+func (p parameter) isValid() bool {
+	if !parameterNameRegexp.MatchString(p.Name) || slices.Contains(reservedParameters, p.Name) {
+		return false
+	}
+	if p.Pattern != "" {
+		if _, err := compilePattern(p.Pattern); err != nil {
+			return false
+		}
+	}
+	return p.Default == "" || p.validate(p.Default) == nil
+}
+
+// compilePattern compiles the pattern of a parameter anchored at both ends, so it must match the whole
+// value and not just a part of it
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if compiled, found := parameterPatterns.Load(pattern); found {
+		return compiled.(*regexp.Regexp), nil
+	}
+	compiled, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, err
+	}
+	parameterPatterns.Store(pattern, compiled)
+	return compiled, nil
+}
+
+func (p parameter) validate(value string) error {
+	if len(p.Enum) > 0 && !slices.Contains(p.Enum, value) {
+		return fmt.Errorf("invalid parameter %s: must be one of %s", p.Name, strings.Join(p.Enum, ", "))
+	}
+	if p.Pattern != "" {
+		compiled, err := compilePattern(p.Pattern)
+		if err != nil || !compiled.MatchString(value) {
+			return fmt.Errorf("invalid parameter %s: must match %s", p.Name, p.Pattern)
+		}
+	}
+	return nil
+}
+
+// getParameters collects the parameters declared by the script from the query string, form fields or
+// JSON body of the request and returns them, validated, as environment variables.
+func getParameters(r *http.Request, scriptToRun script) ([]environment, error) {
+	if len(scriptToRun.Parameters) == 0 {
+		return nil, nil
+	}
+
+	values, err := getRequestValues(r, scriptToRun.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	var parameters []environment
+	for _, p := range scriptToRun.Parameters {
+		value, found := values[p.Name]
+		if !found {
+			if p.Required {
+				return nil, fmt.Errorf("missing parameter %s", p.Name)
+			}
+			if p.Default == "" {
+				continue
+			}
+			value = p.Default
+		}
+		if err := p.validate(value); err != nil {
+			return nil, err
+		}
+		parameters = append(parameters, environment{Key: p.Name, Value: value})
+	}
+	return parameters, nil
+}
+
+// getRequestValues reads the values of the declared parameters. Other keys are ignored, so JSON bodies such as
+// webhook payloads can have nested objects that are not parameters.
+func getRequestValues(r *http.Request, declared []parameter) (map[string]string, error) {
+	values := make(map[string]string)
+	for key, v := range r.URL.Query() {
+		values[key] = v[0]
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		body := make(map[string]any)
+		decoder := json.NewDecoder(r.Body)
+		decoder.UseNumber()
+		if err := decoder.Decode(&body); err != nil {
+			return nil, fmt.Errorf("invalid JSON body: %v", err)
+		}
+		for _, p := range declared {
+			v, found := body[p.Name]
+			if !found {
+				continue
+			}
+			switch value := v.(type) {
+			case string:
+				values[p.Name] = value
+			case json.Number, bool:
+				values[p.Name] = fmt.Sprint(value)
+			case nil:
+			default:
+				return nil, fmt.Errorf("invalid parameter %s: must be a string, number or boolean", p.Name)
+			}
+		}
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		err := r.ParseMultipartForm(defaultMaxBodySize)
+		if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return nil, fmt.Errorf("invalid form body: %v", err)
+		}
+		for key, v := range r.PostForm {
+			values[key] = v[0]
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetParameters(t *testing.T) {
+	parameters := []parameter{
+		{Name: "BRANCH", Required: true, Pattern: "^[a-z]+$"},
+		{Name: "ENV", Default: "staging", Enum: []string{"staging", "production"}},
+		{Name: "DRY_RUN"},
+		{Name: "TAG", Pattern: "[a-z]+"},
+	}
+	tests := []struct {
+		name          string
+		endpoint      string
+		contentType   string
+		body          string
+		expected      []environment
+		expectedError string
+	}{
+		{
+			"When parameters come in the query string then they should be used",
+			"/hook?BRANCH=main&ENV=production",
+			"",
+			"",
+			[]environment{{Key: "BRANCH", Value: "main"}, {Key: "ENV", Value: "production"}},
+			"",
+		},
+		{
+			"When an optional parameter is missing then its default should be used",
+			"/hook?BRANCH=main",
+			"",
+			"",
+			[]environment{{Key: "BRANCH", Value: "main"}, {Key: "ENV", Value: "staging"}},
+			"",
+		},
+		{
+			"When parameters come in a form then they should be used",
+			"/hook",
+			"application/x-www-form-urlencoded",
+			"BRANCH=main&DRY_RUN=1",
+			[]environment{{Key: "BRANCH", Value: "main"}, {Key: "ENV", Value: "staging"}, {Key: "DRY_RUN", Value: "1"}},
+			"",
+		},
+		{
+			"When parameters come in a JSON body then they should be used",
+			"/hook",
+			"application/json",
+			`{"BRANCH": "main", "DRY_RUN": true, "UNDECLARED": 3}`,
+			[]environment{{Key: "BRANCH", Value: "main"}, {Key: "ENV", Value: "staging"}, {Key: "DRY_RUN", Value: "true"}},
+			"",
+		},
+		{
+			"When a required parameter is missing then it should fail",
+			"/hook?ENV=production",
+			"",
+			"",
+			nil,
+			"missing parameter BRANCH",
+		},
+		{
+			"When a parameter doesn't match its pattern then it should fail",
+			"/hook?BRANCH=main%3Breboot",
+			"",
+			"",
+			nil,
+			"invalid parameter BRANCH: must match ^[a-z]+$",
+		},
+		{
+			"When a pattern is not anchored then it should still match the whole value",
+			"/hook?BRANCH=main&TAG=abc%3B%20rm%20-rf%20%2F",
+			"",
+			"",
+			nil,
+			"invalid parameter TAG: must match [a-z]+",
+		},
+		{
+			"When a JSON body has nested objects that are not parameters then they should be ignored",
+			"/hook",
+			"application/json",
+			`{"BRANCH": "main", "repository": {"name": "shellhook"}, "commits": [{"id": "1"}]}`,
+			[]environment{{Key: "BRANCH", Value: "main"}, {Key: "ENV", Value: "staging"}},
+			"",
+		},
+		{
+			"When a parameter is not one of the allowed values then it should fail",
+			"/hook?BRANCH=main&ENV=dev",
+			"",
+			"",
+			nil,
+			"invalid parameter ENV: must be one of staging, production",
+		},
+		{
+			"When a JSON parameter is not a scalar then it should fail",
+			"/hook",
+			"application/json",
+			`{"BRANCH": ["main"]}`,
+			nil,
+			"invalid parameter BRANCH: must be a string, number or boolean",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", test.endpoint, strings.NewReader(test.body))
+			req.Header.Set("Content-Type", test.contentType)
+			result, err := getParameters(req, script{Parameters: parameters})
+			if test.expectedError != "" {
+				assert.EqualError(t, err, test.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, result)
+		})
+	}
+}
+
+func TestParameterIsValid(t *testing.T) {
+	assert.True(t, parameter{Name: "BRANCH", Pattern: "^[a-z]+$", Default: "main"}.isValid())
+	assert.False(t, parameter{Name: "MY-BRANCH"}.isValid())
+	assert.False(t, parameter{Name: "script"}.isValid())
+	assert.False(t, parameter{Name: "BRANCH", Pattern: "[a-z"}.isValid())
+	assert.False(t, parameter{Name: "ENV", Enum: []string{"staging"}, Default: "production"}.isValid())
+}
+
+func TestParametersFromWebhookPayload(t *testing.T) {
+	router := getRouter(configuration{DefaultToken: "test", Scripts: []script{{
+		ID:         parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"),
+		Inline:     `echo "$ref"`,
+		Parameters: []parameter{{Name: "ref", Required: true}},
+	}}})
+	payload := `{"ref": "refs/heads/main", "repository": {"full_name": "jadolg/shellhook"}, "pusher": {"name": "jadolg"}, "commits": []}`
+	req, _ := http.NewRequest("POST", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", strings.NewReader(payload))
+	req.Header.Set("Authorization", "test")
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "refs/heads/main\n", rr.Body.String())
+}
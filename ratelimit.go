@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bucketIdleTimeout is how long a bucket can go unused before it is forgotten
+const bucketIdleTimeout = time.Hour
+
+// rateLimit allows Requests per Interval, with bursts of up to Burst requests (Requests by default)
+type rateLimit struct {
+	Requests int           `yaml:"requests"`
+	Interval time.Duration `yaml:"interval"`
+	Burst    int           `yaml:"burst,omitempty"`
+}
+
+func (l rateLimit) isSet() bool {
+	return l.Requests > 0
+}
+
+func (l rateLimit) isValid() bool {
+	if l == (rateLimit{}) {
+		return true
+	}
+	return l.Requests > 0 && l.Interval > 0 && l.Burst >= 0
+}
+
+func (l rateLimit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+type tokenBucket struct {
+	limit    rateLimit
+	tokens   float64
+	lastSeen time.Time
+}
+
+// rateLimiter keeps a token bucket per key. Buckets outlive configuration reloads and are
+// reset only when their limit changes.
+type rateLimiter struct {
+	mu         sync.Mutex
+	buckets    map[string]*tokenBucket
+	lastPruned time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket), lastPruned: time.Now()}
+}
+
+// allow takes a token from the bucket of key and, when there are none left, returns how long
+// until the next one is available
+func (rl *rateLimiter) allow(key string, limit rateLimit) (bool, time.Duration) {
+	if !limit.isSet() {
+		return true, 0
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	rl.prune(now)
+
+	bucket, found := rl.buckets[key]
+	if !found || bucket.limit != limit {
+		bucket = &tokenBucket{limit: limit, tokens: limit.capacity(), lastSeen: now}
+		rl.buckets[key] = bucket
+	}
+
+	perSecond := float64(limit.Requests) / limit.Interval.Seconds()
+	bucket.tokens = math.Min(limit.capacity(), bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*perSecond)
+	bucket.lastSeen = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// prune must be called with the lock held
+func (rl *rateLimiter) prune(now time.Time) {
+	if now.Sub(rl.lastPruned) < bucketIdleTimeout {
+		return
+	}
+	rl.lastPruned = now
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.lastSeen) > bucketIdleTimeout {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// check goes through the limits in order and stops at the first one that is exhausted
+func (rl *rateLimiter) check(limits ...keyedRateLimit) *ClientError {
+	for _, l := range limits {
+		if allowed, retryAfter := rl.allow(l.key, l.limit); !allowed {
+			rateLimitedTotal.Inc()
+			return &ClientError{
+				Message:    "Too many requests",
+				HTTPCode:   http.StatusTooManyRequests,
+				RetryAfter: retryAfter,
+			}
+		}
+	}
+	return nil
+}
+
+type keyedRateLimit struct {
+	key   string
+	limit rateLimit
+}
+
+func retryAfterSeconds(retryAfter time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+}
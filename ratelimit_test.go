@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	limiter := newRateLimiter()
+	limit := rateLimit{Requests: 10, Interval: time.Second, Burst: 2}
+
+	allowed, _ := limiter.allow("key", limit)
+	assert.True(t, allowed)
+	allowed, _ = limiter.allow("key", limit)
+	assert.True(t, allowed)
+	allowed, retryAfter := limiter.allow("key", limit)
+	assert.False(t, allowed)
+	assert.Greater(t, retryAfter, time.Duration(0))
+	assert.LessOrEqual(t, retryAfter, 100*time.Millisecond)
+
+	allowed, _ = limiter.allow("another key", limit)
+	assert.True(t, allowed, "buckets should be independent")
+
+	time.Sleep(retryAfter + 10*time.Millisecond)
+	allowed, _ = limiter.allow("key", limit)
+	assert.True(t, allowed, "the bucket should refill over time")
+
+	allowed, _ = limiter.allow("unlimited", rateLimit{})
+	assert.True(t, allowed)
+}
+
+func TestRateLimits(t *testing.T) {
+	scriptID := parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	oncePerMinute := rateLimit{Requests: 1, Interval: time.Minute}
+	tests := []struct {
+		name          string
+		configuration configuration
+		calls         []string
+		expectedCodes []int
+	}{
+		{
+			"When the script limit is exceeded then it should return 429",
+			configuration{DefaultToken: "test", Scripts: []script{{ID: scriptID, Inline: "echo ok", RateLimit: oncePerMinute}}},
+			[]string{"test", "test"},
+			[]int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			"When the global limit is exceeded then it should return 429",
+			configuration{DefaultToken: "test", RateLimit: oncePerMinute, Scripts: []script{{ID: scriptID, Inline: "echo ok"}}},
+			[]string{"test", "test"},
+			[]int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			"When the client limit is exceeded then it should return 429 even without a valid token",
+			configuration{DefaultToken: "test", ClientRateLimit: oncePerMinute, Scripts: []script{{ID: scriptID, Inline: "echo ok"}}},
+			[]string{"nonya", "test"},
+			[]int{http.StatusUnauthorized, http.StatusTooManyRequests},
+		},
+		{
+			"When a credential limit is exceeded then other credentials should still be allowed",
+			configuration{DefaultTokens: []credential{{Name: "ci", Token: "ci-token", RateLimit: oncePerMinute}, {Name: "cron", Token: "cron-token"}}, Scripts: []script{{ID: scriptID, Inline: "echo ok"}}},
+			[]string{"ci-token", "ci-token", "cron-token"},
+			[]int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+		},
+		{
+			"When an unauthorized request is made then it should not count for the script limit",
+			configuration{DefaultToken: "test", Scripts: []script{{ID: scriptID, Inline: "echo ok", RateLimit: oncePerMinute}}},
+			[]string{"nonya", "test"},
+			[]int{http.StatusUnauthorized, http.StatusOK},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := getRouter(test.configuration)
+			for i, token := range test.calls {
+				req, _ := http.NewRequest("GET", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", nil)
+				req.Header.Set("Authorization", token)
+				req.RemoteAddr = "192.0.2.1:41234"
+				rr := httptest.NewRecorder()
+				router.ServeHTTP(rr, req)
+				assert.Equal(t, test.expectedCodes[i], rr.Code)
+				if rr.Code == http.StatusTooManyRequests {
+					assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitIsValid(t *testing.T) {
+	assert.True(t, rateLimit{}.isValid())
+	assert.True(t, rateLimit{Requests: 5, Interval: time.Minute, Burst: 10}.isValid())
+	assert.False(t, rateLimit{Requests: 5}.isValid())
+	assert.False(t, rateLimit{Interval: time.Minute}.isValid())
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const defaultMaxBodySize = 1 << 20
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// readBody reads the whole request body, up to the script's limit, and puts it back in the
+// request so it can be read again. Bodies that the script doesn't use are not read.
+func readBody(r *http.Request, scriptToRun script) ([]byte, error) {
+	if !usesBody(r, scriptToRun) {
+		return nil, nil
+	}
+	limit := scriptToRun.MaxBodySize
+	if limit <= 0 {
+		limit = defaultMaxBodySize
+	}
+
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, limit))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, fmt.Errorf("%w: the limit is %d bytes", errBodyTooLarge, limit)
+		}
+		return nil, fmt.Errorf("error reading request body: %v", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// usesBody tells if the script needs the request body: to pass it through stdin, to verify its
+// signature or to read parameters from a form or JSON body
+func usesBody(r *http.Request, scriptToRun script) bool {
+	if scriptToRun.Stdin || scriptToRun.Auth.signsBody() {
+		return true
+	}
+	if len(scriptToRun.Parameters) == 0 {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json" || mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+}
+
+// getRequestEnvironment describes the request to the script through environment variables
+func getRequestEnvironment(r *http.Request, scriptToRun script) []environment {
+	requestEnvironment := []environment{
+		{Key: "SHELLHOOK_METHOD", Value: r.Method},
+		{Key: "SHELLHOOK_CONTENT_TYPE", Value: r.Header.Get("Content-Type")},
+	}
+	for _, header := range scriptToRun.Headers {
+		requestEnvironment = append(requestEnvironment, environment{Key: headerVariable(header), Value: r.Header.Get(header)})
+	}
+	return requestEnvironment
+}
+
+func headerVariable(header string) string {
+	return "SHELLHOOK_HEADER_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		default:
+			return '_'
+		}
+	}, header)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestBody(t *testing.T) {
+	scriptID := parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	tests := []struct {
+		name         string
+		script       script
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			"When stdin is enabled then the body should be passed to the script",
+			script{ID: scriptID, Inline: "cat", Stdin: true},
+			`{"ref": "refs/heads/main"}`,
+			http.StatusOK,
+			`{"ref": "refs/heads/main"}`,
+		},
+		{
+			"When stdin is not enabled then the body should not be passed to the script",
+			script{ID: scriptID, Inline: "cat"},
+			`{"ref": "refs/heads/main"}`,
+			http.StatusOK,
+			"",
+		},
+		{
+			"When the body is larger than the limit then it should return 413",
+			script{ID: scriptID, Inline: "cat", Stdin: true, MaxBodySize: 4},
+			`{"ref": "refs/heads/main"}`,
+			http.StatusRequestEntityTooLarge,
+			"request body too large: the limit is 4 bytes\n",
+		},
+		{
+			"When the script doesn't use the body then its size should not matter",
+			script{ID: scriptID, Inline: "echo ok", MaxBodySize: 4},
+			`{"ref": "refs/heads/main"}`,
+			http.StatusOK,
+			"ok\n",
+		},
+		{
+			"When the request is described in environment variables then the script should see them",
+			script{ID: scriptID, Inline: "echo $SHELLHOOK_METHOD $SHELLHOOK_CONTENT_TYPE $SHELLHOOK_HEADER_X_GITHUB_EVENT", Headers: []string{"X-GitHub-Event"}},
+			`{}`,
+			http.StatusOK,
+			"POST application/json push\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := getRouter(configuration{DefaultToken: "test", Scripts: []script{test.script}})
+			req, _ := http.NewRequest("POST", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", strings.NewReader(test.body))
+			req.Header.Set("Authorization", "test")
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-GitHub-Event", "push")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, test.expectedCode, rr.Code)
+			assert.Equal(t, test.expectedBody, rr.Body.String())
+		})
+	}
+}
+
+func TestRequestBodyIsReadAfterTheToken(t *testing.T) {
+	router := getRouter(configuration{DefaultToken: "test", Scripts: []script{{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Inline: "cat", Stdin: true, MaxBodySize: 4}}})
+	req, _ := http.NewRequest("POST", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", strings.NewReader(`{"ref": "refs/heads/main"}`))
+	req.Header.Set("Authorization", "nonya")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestHeaderVariable(t *testing.T) {
+	assert.Equal(t, "SHELLHOOK_HEADER_X_GITHUB_EVENT", headerVariable("X-GitHub-Event"))
+	assert.Equal(t, "SHELLHOOK_HEADER_X_REQUEST_ID", headerVariable("x-request-id"))
+}
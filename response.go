@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	responseFormatText = "text"
+	responseFormatJSON = "json"
+)
+
+// defaultExitCode is the key of exit_codes that matches any other non-zero exit code
+const defaultExitCode = "default"
+
+var responseFormats = []string{responseFormatText, responseFormatJSON}
+
+// executionResponse is the body of the JSON response format, which keeps apart what the script
+// printed, how it exited and what went wrong running it
+type executionResponse struct {
+	ScriptID  uuid.UUID `json:"script_id"`
+	ExitCode  *int      `json:"exit_code"`
+	Stdout    string    `json:"stdout"`
+	Stderr    string    `json:"stderr"`
+	Duration  string    `json:"duration"`
+	StartedAt time.Time `json:"started_at"`
+	Truncated bool      `json:"truncated"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func newExecutionResponse(scriptToRun script, result executionResult, err error) executionResponse {
+	response := executionResponse{
+		ScriptID:  scriptToRun.ID,
+		Stdout:    string(result.Stdout),
+		Stderr:    string(result.Stderr),
+		Duration:  result.Duration.String(),
+		StartedAt: result.StartedAt,
+		Truncated: result.Truncated,
+	}
+	if result.ExitCode >= 0 {
+		exitCode := result.ExitCode
+		response.ExitCode = &exitCode
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	return response
+}
+
+// isJSONResponse tells if the result must be sent as JSON, because the script is configured that way
+// or the client asks for it
+func isJSONResponse(r *http.Request, scriptToRun script) bool {
+	return scriptToRun.ResponseFormat == responseFormatJSON || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func isValidResponseFormat(format string) bool {
+	return format == "" || slices.Contains(responseFormats, format)
+}
+
+func isValidExitCodes(exitCodes map[string]int) bool {
+	for exitCode, status := range exitCodes {
+		if _, err := strconv.Atoi(exitCode); err != nil && exitCode != defaultExitCode {
+			return false
+		}
+		if status < 100 || status > 599 {
+			return false
+		}
+	}
+	return true
+}
+
+// responseStatus returns the HTTP status for the result of the script using its exit_codes. The default entry
+// applies to the non-zero exit codes that are not listed, and scripts that didn't exit keep the error status.
+func (s script) responseStatus(result executionResult, err error) int {
+	if err != nil && (result.ExitCode < 0 || errors.Is(err, errScriptTimeout)) {
+		return errorStatus(err)
+	}
+	if status, found := s.ExitCodes[strconv.Itoa(result.ExitCode)]; found {
+		return status
+	}
+	if err == nil {
+		return http.StatusOK
+	}
+	if status, found := s.ExitCodes[defaultExitCode]; found {
+		return status
+	}
+	return errorStatus(err)
+}
+
+// countResult counts failed executions answered with a server error. Other statuses are outcomes the
+// script chose through exit_codes. The failure itself is logged by executeScript.
+func countResult(status int, err error) {
+	if err != nil && status >= http.StatusInternalServerError {
+		errorsTotal.Inc()
+	}
+}
+
+// visibleResult removes what callers must not see, whatever the way they get the result: the output of
+// scripts configured with hide_output and the stderr of scripts without return_stderr. The rest is only
+// seen in the logs.
+func (s script) visibleResult(result executionResult) executionResult {
+	if s.HideOutput {
+		result.Stdout, result.Stderr = nil, nil
+	}
+	if !s.ReturnStderr {
+		result.Stderr = nil
+	}
+	return result
+}
+
+func respondExecution(w http.ResponseWriter, scriptToRun script, result executionResult, err error) {
+	status := scriptToRun.responseStatus(result, err)
+	countResult(status, err)
+	respondJSON(w, status, newExecutionResponse(scriptToRun, scriptToRun.visibleResult(result), err))
+}
+
+// respondText sends the output of the script, followed by stderr if return_stderr is set and the error
+// when the status is not a successful one
+func respondText(w http.ResponseWriter, scriptToRun script, result executionResult, err error) {
+	status := scriptToRun.responseStatus(result, err)
+	countResult(status, err)
+	result = scriptToRun.visibleResult(result)
+	if result.Truncated {
+		w.Header().Set("X-Output-Truncated", "true")
+	}
+	if err != nil && status >= http.StatusBadRequest {
+		http.Error(w, fmt.Sprintf("%s%s%v", result.Stdout, result.Stderr, err), status)
+		return
+	}
+	w.WriteHeader(status)
+	_, err = fmt.Fprintf(w, "%s", result.Stdout)
+	if err != nil {
+		log.Errorf("error responding to request %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestJSONResponse(t *testing.T) {
+	scriptID := parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	tests := []struct {
+		name             string
+		script           script
+		accept           string
+		expectedCode     int
+		expectedExitCode int
+		expectedStdout   string
+		expectedStderr   string
+		expectedError    string
+	}{
+		{
+			"When the client accepts JSON then stdout and stderr should be reported separately",
+			script{ID: scriptID, Inline: "echo out; echo err >&2", ReturnStderr: true},
+			"application/json",
+			http.StatusOK, 0, "out\n", "err\n", "",
+		},
+		{
+			"When the script fails then the exit code should be reported",
+			script{ID: scriptID, Inline: "echo out; echo err >&2; exit 3", ReturnStderr: true},
+			"application/json",
+			http.StatusInternalServerError, 3, "out\n", "err\n", "exit status 3",
+		},
+		{
+			"When return_stderr is not set then stderr should not be reported",
+			script{ID: scriptID, Inline: "echo out; echo err >&2; exit 3"},
+			"application/json",
+			http.StatusInternalServerError, 3, "out\n", "", "exit status 3",
+		},
+		{
+			"When the script is configured to respond with JSON then the Accept header should not matter",
+			script{ID: scriptID, Inline: "echo out", ResponseFormat: responseFormatJSON},
+			"",
+			http.StatusOK, 0, "out\n", "", "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := getRouter(configuration{DefaultToken: "test", Scripts: []script{test.script}})
+			req, _ := http.NewRequest("GET", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", nil)
+			req.Header.Set("Authorization", "test")
+			req.Header.Set("Accept", test.accept)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, test.expectedCode, rr.Code)
+			assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+			var response executionResponse
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			assert.Equal(t, scriptID, response.ScriptID)
+			require.NotNil(t, response.ExitCode)
+			assert.Equal(t, test.expectedExitCode, *response.ExitCode)
+			assert.Equal(t, test.expectedStdout, response.Stdout)
+			assert.Equal(t, test.expectedStderr, response.Stderr)
+			assert.Equal(t, test.expectedError, response.Error)
+			assert.NotEmpty(t, response.Duration)
+			assert.False(t, response.StartedAt.IsZero())
+		})
+	}
+}
+
+func TestResponseFormatIsValid(t *testing.T) {
+	assert.True(t, script{Inline: "echo ok", ResponseFormat: responseFormatText}.isValid())
+	assert.True(t, script{Inline: "echo ok", ResponseFormat: responseFormatJSON}.isValid())
+	assert.False(t, script{Inline: "echo ok", ResponseFormat: "xml"}.isValid())
+}
+
+func TestExitCodes(t *testing.T) {
+	scriptID := parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	exitCodes := map[string]int{"0": http.StatusOK, "3": http.StatusConflict, "4": http.StatusUnprocessableEntity, "5": http.StatusAccepted, "default": http.StatusBadGateway}
+	tests := []struct {
+		name         string
+		inline       string
+		exitCodes    map[string]int
+		expectedCode int
+		expectedBody string
+	}{
+		{"When the exit code is listed then its status should be used", "echo nothing to do; exit 3", exitCodes, http.StatusConflict, "nothing to do\nexit status 3\n"},
+		{"When the exit code is mapped to a success then only the output should be sent", "echo later; exit 5", exitCodes, http.StatusAccepted, "later\n"},
+		{"When the exit code is not listed then the default should be used", "exit 7", exitCodes, http.StatusBadGateway, "exit status 7\n"},
+		{"When the script succeeds then it should return 200 even with a default", "echo ok", map[string]int{"default": http.StatusBadGateway}, http.StatusOK, "ok\n"},
+		{"When there is no mapping then failures should return 500", "exit 3", nil, http.StatusInternalServerError, "exit status 3\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := getRouter(configuration{DefaultToken: "test", Scripts: []script{{ID: scriptID, Inline: test.inline, ExitCodes: test.exitCodes}}})
+			req, _ := http.NewRequest("GET", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", nil)
+			req.Header.Set("Authorization", "test")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, test.expectedCode, rr.Code)
+			assert.Equal(t, test.expectedBody, rr.Body.String())
+		})
+	}
+}
+
+func TestExitCodesTimeout(t *testing.T) {
+	scriptToRun := script{ExitCodes: map[string]int{"default": http.StatusBadGateway}}
+	assert.Equal(t, http.StatusGatewayTimeout, scriptToRun.responseStatus(executionResult{ExitCode: -1}, errScriptTimeout))
+}
+
+func TestExitCodesAreValid(t *testing.T) {
+	var s script
+	require.NoError(t, yaml.Unmarshal([]byte("exit_codes: {0: 200, 3: 409, 4: 422, default: 500}"), &s))
+	assert.Equal(t, map[string]int{"0": 200, "3": 409, "4": 422, "default": 500}, s.ExitCodes)
+	assert.True(t, isValidExitCodes(s.ExitCodes))
+
+	assert.False(t, isValidExitCodes(map[string]int{"other": 500}))
+	assert.False(t, isValidExitCodes(map[string]int{"1": 1000}))
+}
+
+func TestOutputVisibility(t *testing.T) {
+	scriptID := parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	tests := []struct {
+		name         string
+		script       script
+		accept       string
+		expectedBody string
+	}{
+		{
+			"When return_stderr is set then failures should include stderr",
+			script{ID: scriptID, Inline: "echo out; echo err >&2; exit 1", ReturnStderr: true},
+			"", "out\nerr\nexit status 1\n",
+		},
+		{
+			"When return_stderr is set then successful responses should only include stdout",
+			script{ID: scriptID, Inline: "echo out; echo err >&2", ReturnStderr: true},
+			"", "out\n",
+		},
+		{
+			"When hide_output is set then failures should only include the error",
+			script{ID: scriptID, Inline: "echo secret; echo secret >&2; exit 1", ReturnStderr: true, HideOutput: true},
+			"", "exit status 1\n",
+		},
+		{
+			"When hide_output is set then streaming should be disabled",
+			script{ID: scriptID, Inline: "echo secret", Stream: true, HideOutput: true},
+			"", "",
+		},
+		{
+			"When hide_output is set then JSON responses should not include the output",
+			script{ID: scriptID, Inline: "echo secret; echo secret >&2", HideOutput: true},
+			"application/json", "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := getRouter(configuration{DefaultToken: "test", Scripts: []script{test.script}})
+			req, _ := http.NewRequest("GET", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", nil)
+			req.Header.Set("Authorization", "test")
+			req.Header.Set("Accept", test.accept)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if test.accept != "application/json" {
+				assert.Equal(t, test.expectedBody, rr.Body.String())
+				return
+			}
+			assert.NotContains(t, rr.Body.String(), "secret")
+		})
+	}
+}
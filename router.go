@@ -1,33 +1,68 @@
 package main
 
 import (
-	"crypto/subtle"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
-	"net"
 	"net/http"
 	"os"
-	"sync"
+	"strconv"
+	"time"
 )
 
 type ClientError struct {
-	Message  string `json:"message"`
-	HTTPCode int    `json:"code"`
+	Message    string        `json:"message"`
+	HTTPCode   int           `json:"code"`
+	RetryAfter time.Duration `json:"-"`
 }
 
-func executionHandler(c configuration, locks map[uuid.UUID]*sync.Mutex) func(w http.ResponseWriter, r *http.Request) {
+func executionHandler(s *server) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		scriptToRun, err := c.getScript(r.URL.Query().Get("script"))
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		c, locks := s.current()
+		scriptToRun, cliErr := getRequestedScript(r, c)
+		if cliErr != nil {
+			http.Error(w, cliErr.Message, cliErr.HTTPCode)
+			return
+		}
+
+		if allow, cliErr := checkMethod(r, scriptToRun, c); cliErr != nil {
+			w.Header().Set("Allow", allow)
+			http.Error(w, cliErr.Message, cliErr.HTTPCode)
 			return
 		}
 
-		remoteIP := getRemoteIP(r)
+		remoteIP := getRemoteIP(r, c.TrustedProxies)
 
-		cliErr := checkAuthorization(r.Header.Get("Authorization"), scriptToRun, c)
+		if cliErr := checkNetwork(remoteIP, scriptToRun, c); cliErr != nil {
+			log.WithFields(log.Fields{
+				"ID":     scriptToRun.ID,
+				"Client": remoteIP,
+			}).Warning("Client not allowed")
+			http.Error(w, cliErr.Message, cliErr.HTTPCode)
+			return
+		}
+
+		if cliErr := s.limiter.check(keyedRateLimit{"client:" + remoteIP, c.ClientRateLimit}); cliErr != nil {
+			reportRateLimited(w, cliErr, scriptToRun, remoteIP)
+			return
+		}
+
+		// Signatures cover the body, so it is read before the authorization only for those modes
+		var body []byte
+		var err error
+		if scriptToRun.Auth.signsBody() {
+			if body, err = readBody(r, scriptToRun); err != nil {
+				reportBodyError(w, err)
+				return
+			}
+		}
+
+		var usedCredential credential
+		usedCredential, cliErr = checkAuthorization(r, body, scriptToRun, c)
 		if cliErr != nil {
 			log.WithFields(log.Fields{
 				"Error":  cliErr.Message,
@@ -37,53 +72,311 @@ func executionHandler(c configuration, locks map[uuid.UUID]*sync.Mutex) func(w h
 			return
 		}
 
+		cliErr = s.limiter.check(
+			keyedRateLimit{"global", c.RateLimit},
+			keyedRateLimit{"script:" + scriptToRun.ID.String(), scriptToRun.RateLimit},
+			keyedRateLimit{"credential:" + usedCredential.scope + ":" + usedCredential.Name, usedCredential.RateLimit},
+		)
+		if cliErr != nil {
+			reportRateLimited(w, cliErr, scriptToRun, remoteIP)
+			return
+		}
+
+		if !scriptToRun.Auth.signsBody() {
+			if body, err = readBody(r, scriptToRun); err != nil {
+				reportBodyError(w, err)
+				return
+			}
+		}
+
+		parameters, err := getParameters(r, scriptToRun)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts := executionOptions{
+			environment:    append(getRequestEnvironment(r, scriptToRun), parameters...),
+			maxOutputBytes: scriptToRun.maxOutputBytes(c),
+		}
+		if scriptToRun.Stdin {
+			opts.stdin = bytes.NewReader(body)
+		}
+		t := trigger{credential: usedCredential.Name, client: remoteIP, parameters: parameters}
+
 		log.WithFields(log.Fields{
 			"ID":         scriptToRun.ID,
+			"Name":       scriptToRun.Name,
 			"Path":       scriptToRun.Path,
 			"Inline":     scriptToRun.Inline != "",
 			"Concurrent": scriptToRun.Concurrent,
 			"Shell":      scriptToRun.Shell,
 			"User":       scriptToRun.User,
 			"Client":     remoteIP,
+			"Credential": usedCredential.Name,
 		}).Info("Executing script")
 
-		if !scriptToRun.Concurrent {
-			log.WithFields(log.Fields{"ID": scriptToRun.ID}).Debug("Acquiring lock for script")
-			locks[scriptToRun.ID].Lock()
-			defer locks[scriptToRun.ID].Unlock()
+		gate := locks[scriptToRun.lockKey()]
+		var unlock func()
+		if scriptToRun.WhenBusy == whenBusyReject || scriptToRun.WhenBusy == whenBusyCoalesce {
+			unlock, err = tryAcquireScript(scriptToRun, gate)
+			if errors.Is(err, errScriptBusy) {
+				s.handleBusy(w, scriptToRun, c, gate, opts, t)
+				return
+			}
+			if err != nil {
+				reportError(err, w)
+				return
+			}
+		}
+
+		if isAsync(r, scriptToRun) {
+			wait := func() (func(), error) { return waitForScript(s.stopping, scriptToRun, gate) }
+			if unlock != nil {
+				wait = func() (func(), error) { return unlock, nil }
+			} else if err := gate.join(); err != nil {
+				reportBusy(w, err, scriptToRun, remoteIP)
+				return
+			}
+			j := s.jobs.create(scriptToRun.ID)
+			log.WithFields(log.Fields{"ID": scriptToRun.ID, "Job": j.ID}).Info("Script scheduled for asynchronous execution")
+			s.background.Go(func() { s.runJob(j.ID, scriptToRun, c, opts, t, wait) })
+
+			w.Header().Set("Location", jobURL(j.ID))
+			respondJSON(w, http.StatusAccepted, j)
+			return
 		}
 
-		output, err := executeScript(scriptToRun, c.Environment)
+		if unlock == nil {
+			ctx, cancel := s.untilStopping(r.Context())
+			unlock, err = acquireLock(ctx, scriptToRun, locks)
+			cancel()
+			if err != nil {
+				if s.stopping.Err() != nil {
+					err = errShuttingDown
+				}
+				reportBusy(w, err, scriptToRun, remoteIP)
+				return
+			}
+		}
+		defer unlock()
+
+		if isStreaming(r, scriptToRun) {
+			stream := newOutputStream(w, acceptsEventStream(r))
+			opts.stdout = stream.stdout
+			if scriptToRun.ReturnStderr {
+				opts.stderr = stream.stderr
+			}
+			result, err := executeScript(scriptToRun, c.Environment, opts)
+			stream.finish(result, err)
+			s.history.record(newExecution(scriptToRun, t, result, err))
+			if err != nil {
+				errorsTotal.Inc()
+			}
+			return
+		}
+
+		result, err := executeScript(scriptToRun, c.Environment, opts)
+		s.history.record(newExecution(scriptToRun, t, result, err))
+		if isJSONResponse(r, scriptToRun) {
+			respondExecution(w, scriptToRun, result, err)
+			return
+		}
+		respondText(w, scriptToRun, result, err)
+	}
+}
+
+// getRequestedScript finds the script from the /hooks/{hook} path or the /hook?script= query
+func getRequestedScript(r *http.Request, c configuration) (script, *ClientError) {
+	if hook := r.PathValue("hook"); hook != "" {
+		scriptToRun, err := c.getHook(hook)
 		if err != nil {
-			reportError(err, w)
+			return script{}, &ClientError{Message: err.Error(), HTTPCode: http.StatusNotFound}
+		}
+		return scriptToRun, nil
+	}
+
+	scriptToRun, err := c.getScript(r.URL.Query().Get("script"))
+	if err != nil {
+		return script{}, &ClientError{Message: err.Error(), HTTPCode: http.StatusBadRequest}
+	}
+	return scriptToRun, nil
+}
+
+func isAsync(r *http.Request, scriptToRun script) bool {
+	if async, err := strconv.ParseBool(r.URL.Query().Get("async")); err == nil {
+		return async
+	}
+	return scriptToRun.Async
+}
+
+// acquireLock waits until the script can run, giving up if the queue of the script is full or ctx is done
+func acquireLock(ctx context.Context, scriptToRun script, locks map[string]*executionGate) (func(), error) {
+	log.WithFields(log.Fields{"ID": scriptToRun.ID, "Lock": scriptToRun.lockKey()}).Debug("Acquiring lock for script")
+	gate := locks[scriptToRun.lockKey()]
+	if err := gate.join(); err != nil {
+		return nil, err
+	}
+	return waitForScript(ctx, scriptToRun, gate)
+}
+
+// handleBusy answers a request for a script that can't start right away, rejecting it or adding it
+// to the follow-up run according to the when_busy setting
+func (s *server) handleBusy(w http.ResponseWriter, scriptToRun script, c configuration, gate *executionGate, opts executionOptions, t trigger) {
+	if scriptToRun.WhenBusy == whenBusyReject {
+		log.WithFields(log.Fields{"ID": scriptToRun.ID, "Client": t.client}).Warning("Script busy, request rejected")
+		http.Error(w, errScriptBusy.Error(), http.StatusConflict)
+		return
+	}
+
+	jobID, scheduled, err := gate.coalesce(scriptToRun.ID, func() uuid.UUID { return s.jobs.create(scriptToRun.ID).ID })
+	if err != nil {
+		reportBusy(w, err, scriptToRun, t.client)
+		return
+	}
+	if scheduled {
+		s.background.Go(func() {
+			s.runJob(jobID, scriptToRun, c, opts, t, func() (func(), error) {
+				unlock, err := waitForScript(s.stopping, scriptToRun, gate)
+				gate.followUpStarted(scriptToRun.ID)
+				return unlock, err
+			})
+		})
+	}
+	log.WithFields(log.Fields{"ID": scriptToRun.ID, "Job": jobID, "Client": t.client}).Info("Script busy, request coalesced into the follow-up run")
+
+	j, _ := s.jobs.get(jobID)
+	w.Header().Set("Location", jobURL(jobID))
+	respondJSON(w, http.StatusAccepted, j)
+}
+
+// runJob runs a job once wait gives it its turn
+func (s *server) runJob(jobID uuid.UUID, scriptToRun script, c configuration, opts executionOptions, t trigger, wait func() (func(), error)) {
+	unlock, err := wait()
+	if err != nil {
+		s.jobs.finish(jobID, executionResult{ExitCode: -1}, err)
+		return
+	}
+	defer unlock()
+
+	s.jobs.start(jobID)
+	result, err := executeScript(scriptToRun, c.Environment, opts)
+	s.jobs.finish(jobID, scriptToRun.visibleResult(result), err)
+	t.jobID = &jobID
+	s.history.record(newExecution(scriptToRun, t, result, err))
+	if err != nil {
+		errorsTotal.Inc()
+	}
+}
+
+func jobHandler(s *server) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, _ := s.current()
+		jobID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid job ID: %s", r.PathValue("id")), http.StatusBadRequest)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		_, err = fmt.Fprintf(w, "%s", output)
+
+		j, found := s.jobs.get(jobID)
+		if !found {
+			http.Error(w, fmt.Sprintf("job not found: %s", jobID), http.StatusNotFound)
+			return
+		}
+
+		scriptToRun, err := c.getScript(j.ScriptID.String())
 		if err != nil {
-			log.Errorf("error responding to request %v", err)
+			http.Error(w, fmt.Sprintf("job not found: %s", jobID), http.StatusNotFound)
+			return
+		}
+
+		remoteIP := getRemoteIP(r, c.TrustedProxies)
+		cliErr := checkNetwork(remoteIP, scriptToRun, c)
+		if cliErr == nil {
+			if cliErr = s.limiter.check(keyedRateLimit{"client:" + remoteIP, c.ClientRateLimit}); cliErr != nil {
+				reportRateLimited(w, cliErr, scriptToRun, remoteIP)
+				return
+			}
+			_, cliErr = checkAuthorization(r, nil, scriptToRun, c)
 		}
+		if cliErr != nil {
+			log.WithFields(log.Fields{
+				"Error":  cliErr.Message,
+				"Client": remoteIP,
+			}).Warning("Authorization error")
+			http.Error(w, cliErr.Message, cliErr.HTTPCode)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, j)
+	}
+}
+
+func jobURL(id uuid.UUID) string {
+	return fmt.Sprintf("/jobs/%s", id)
+}
+
+func respondJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Errorf("error responding to request %v", err)
 	}
 }
 
-func getRemoteIP(r *http.Request) string {
-	clientIP := r.RemoteAddr
-	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-		clientIP = forwardedFor
+func reportRateLimited(w http.ResponseWriter, cliErr *ClientError, scriptToRun script, remoteIP string) {
+	log.WithFields(log.Fields{
+		"ID":         scriptToRun.ID,
+		"Client":     remoteIP,
+		"RetryAfter": cliErr.RetryAfter.String(),
+	}).Warning("Rate limit exceeded")
+	w.Header().Set("Retry-After", retryAfterSeconds(cliErr.RetryAfter))
+	http.Error(w, cliErr.Message, cliErr.HTTPCode)
+}
+
+func reportBusy(w http.ResponseWriter, err error, scriptToRun script, remoteIP string) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		log.WithFields(log.Fields{"ID": scriptToRun.ID, "Client": remoteIP}).Debug("Client went away while waiting for the script")
+		return
 	}
-	ip, _, splitErr := net.SplitHostPort(clientIP)
-	if splitErr == nil {
-		clientIP = ip
+	if errors.Is(err, errShuttingDown) {
+		log.WithFields(log.Fields{"ID": scriptToRun.ID, "Client": remoteIP}).Info("Request given up while waiting for the script, shutting down")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
 	}
-	return clientIP
+	if !errors.Is(err, errQueueFull) {
+		reportError(err, w)
+		return
+	}
+	log.WithFields(log.Fields{
+		"ID":     scriptToRun.ID,
+		"Client": remoteIP,
+	}).Warning("Execution queue full")
+	queueFullTotal.Inc()
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+}
+
+func reportBodyError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, errBodyTooLarge) {
+		status = http.StatusRequestEntityTooLarge
+	}
+	http.Error(w, err.Error(), status)
 }
 
 func reportError(err error, w http.ResponseWriter) {
 	log.Error(err)
-	http.Error(w, err.Error(), http.StatusInternalServerError)
+	http.Error(w, err.Error(), errorStatus(err))
 	errorsTotal.Inc()
 }
 
+func errorStatus(err error) int {
+	if errors.Is(err, errScriptTimeout) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
 func createTemporaryScriptFromInline(scriptToRun script) (string, error) {
 	tempScript, err := os.CreateTemp(os.TempDir(), "*-shellhook")
 
@@ -99,18 +392,6 @@ func createTemporaryScriptFromInline(scriptToRun script) (string, error) {
 	return tempScript.Name(), nil
 }
 
-func checkAuthorization(authHeader string, scriptToRun script, c configuration) *ClientError {
-	if authHeader == "" {
-		return &ClientError{Message: "Missing authorization token", HTTPCode: http.StatusUnauthorized}
-	}
-
-	if (scriptToRun.Token != "" && subtle.ConstantTimeCompare([]byte(authHeader), []byte(scriptToRun.Token)) != 1) ||
-		(scriptToRun.Token == "" && subtle.ConstantTimeCompare([]byte(authHeader), []byte(c.DefaultToken)) != 1) {
-		return &ClientError{Message: "Invalid authorization token", HTTPCode: http.StatusUnauthorized}
-	}
-	return nil
-}
-
 func healthcheckHandler(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	_, err := fmt.Fprintf(w, "OK")
@@ -118,12 +399,3 @@ func healthcheckHandler(w http.ResponseWriter, _ *http.Request) {
 		log.Errorf("error responding to request %v", err)
 	}
 }
-
-func getRouter(c configuration) *http.ServeMux {
-	locks := getLocks(c)
-	mux := http.NewServeMux()
-	mux.HandleFunc("/hook", executionHandler(c, locks))
-	mux.HandleFunc("/health", healthcheckHandler)
-	mux.Handle("/metrics", promhttp.Handler())
-	return mux
-}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestRouter(t *testing.T) {
@@ -98,6 +101,22 @@ func TestRouter(t *testing.T) {
 			http.StatusOK,
 			"ok\n",
 		},
+		{
+			"When the hook endpoint is called with a token that matches the hash of the script token, it should return 200",
+			"/hook?script=b9f71a96-0d23-11ee-860e-ff55b106c448",
+			configuration{DefaultToken: "test", Scripts: []script{{ID: parseUUIDOrPanic("b9f71a96-0d23-11ee-860e-ff55b106c448"), Path: "./scripts/success.sh", TokenHash: "sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"}}},
+			"secret",
+			http.StatusOK,
+			"ok\n",
+		},
+		{
+			"When the hook endpoint is called with a token that doesn't match the hash of the default token, it should return 401",
+			"/hook?script=b9f71a96-0d23-11ee-860e-ff55b106c448",
+			configuration{DefaultTokenHash: "sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", Scripts: []script{{ID: parseUUIDOrPanic("b9f71a96-0d23-11ee-860e-ff55b106c448"), Path: "./scripts/success.sh"}}},
+			"sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b",
+			http.StatusUnauthorized,
+			"Invalid authorization token\n",
+		},
 		{
 			"When the hook endpoint is called with a script that uses inline then it should return 200",
 			"/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde",
@@ -130,6 +149,54 @@ func TestRouter(t *testing.T) {
 			http.StatusOK,
 			"frodo\n",
 		},
+		{
+			"When a script declares parameters then they should be passed to the script",
+			"/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde&NAME=Gandalf",
+			configuration{DefaultToken: "test", Scripts: []script{{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Inline: "echo $NAME", Parameters: []parameter{{Name: "NAME"}}}}},
+			"test",
+			http.StatusOK,
+			"Gandalf\n",
+		},
+		{
+			"When a parameter is invalid then it should return 400",
+			"/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde&NAME=Gandalf",
+			configuration{DefaultToken: "test", Scripts: []script{{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Inline: "echo $NAME", Parameters: []parameter{{Name: "NAME", Enum: []string{"Frodo"}}}}}},
+			"test",
+			http.StatusBadRequest,
+			"invalid parameter NAME: must be one of Frodo\n",
+		},
+		{
+			"When the hook is called by its name then it should return 200",
+			"/hooks/hello",
+			configuration{DefaultToken: "test", Scripts: []script{{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Name: "hello", Inline: "echo hello"}}},
+			"test",
+			http.StatusOK,
+			"hello\n",
+		},
+		{
+			"When the hook is called by its ID in the path then it should return 200",
+			"/hooks/47878e38-a700-11ee-bc6d-f3d25921fcde",
+			configuration{DefaultToken: "test", Scripts: []script{{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Name: "hello", Inline: "echo hello"}}},
+			"test",
+			http.StatusOK,
+			"hello\n",
+		},
+		{
+			"When a hook that doesn't exist is called then it should return 404",
+			"/hooks/goodbye",
+			configuration{DefaultToken: "test", Scripts: []script{{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Name: "hello", Inline: "echo hello"}}},
+			"test",
+			http.StatusNotFound,
+			"hook not found: goodbye\n",
+		},
+		{
+			"When a script runs longer than its timeout then it should be killed and return 504",
+			"/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde",
+			configuration{DefaultToken: "test", Scripts: []script{{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Inline: "echo started; sleep 5", Timeout: 100 * time.Millisecond}}},
+			"test",
+			http.StatusGatewayTimeout,
+			"started\nscript execution timed out after 100ms\n",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -146,6 +213,19 @@ func TestRouter(t *testing.T) {
 	}
 }
 
+func TestRouterKeepsTheEnvironmentOfTheService(t *testing.T) {
+	t.Setenv("SHELLHOOK_PROBE", "inherited")
+	router := getRouter(configuration{DefaultToken: "test", Scripts: []script{
+		{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Inline: "echo $SHELLHOOK_PROBE $SHELLHOOK_METHOD"},
+	}})
+	req, _ := http.NewRequest("GET", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", nil)
+	req.Header.Set("Authorization", "test")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "inherited GET\n", rr.Body.String())
+}
+
 func parseUUIDOrPanic(s string) uuid.UUID {
 	id, err := uuid.Parse(s)
 	if err != nil {
@@ -153,3 +233,113 @@ func parseUUIDOrPanic(s string) uuid.UUID {
 	}
 	return id
 }
+
+func TestAsyncExecution(t *testing.T) {
+	scriptID := parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	tests := []struct {
+		name           string
+		endpoint       string
+		script         script
+		expectedStatus jobStatus
+		expectedStdout string
+		expectedStderr string
+	}{
+		{
+			"When async is requested in the query then the script should run in the background",
+			"/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde&async=true",
+			script{ID: scriptID, Inline: "echo inline"},
+			jobSucceeded,
+			"inline\n",
+			"",
+		},
+		{
+			"When the script is configured as async then it should run in the background",
+			"/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde",
+			script{ID: scriptID, Inline: "echo ko; echo secret >&2; exit 3", Async: true},
+			jobFailed,
+			"ko\n",
+			"",
+		},
+		{
+			"When return_stderr is set then the job should include stderr",
+			"/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde&async=true",
+			script{ID: scriptID, Inline: "echo ko; echo err >&2; exit 3", ReturnStderr: true},
+			jobFailed,
+			"ko\n",
+			"err\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := getRouter(configuration{DefaultToken: "test", Scripts: []script{test.script}})
+			req, _ := http.NewRequest("POST", test.endpoint, nil)
+			req.Header.Set("Authorization", "test")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			require.Equal(t, http.StatusAccepted, rr.Code)
+
+			var accepted job
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &accepted))
+			assert.Equal(t, scriptID, accepted.ScriptID)
+			assert.Equal(t, jobURL(accepted.ID), rr.Header().Get("Location"))
+
+			var finished job
+			require.Eventually(t, func() bool {
+				req, _ := http.NewRequest("GET", jobURL(accepted.ID), nil)
+				req.Header.Set("Authorization", "test")
+				rr := httptest.NewRecorder()
+				router.ServeHTTP(rr, req)
+				require.Equal(t, http.StatusOK, rr.Code)
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &finished))
+				return finished.isFinished()
+			}, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, test.expectedStatus, finished.Status)
+			assert.Equal(t, test.expectedStdout, finished.Stdout)
+			assert.Equal(t, test.expectedStderr, finished.Stderr)
+			require.NotNil(t, finished.ExitCode)
+			require.NotNil(t, finished.StartedAt)
+			require.NotNil(t, finished.FinishedAt)
+		})
+	}
+}
+
+func TestJobEndpoint(t *testing.T) {
+	c := configuration{DefaultToken: "test", Scripts: []script{{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Inline: "echo inline"}}}
+	router := getRouter(c)
+
+	for _, test := range []struct {
+		name         string
+		endpoint     string
+		expectedCode int
+	}{
+		{"When the job ID is not a UUID then it should return 400", "/jobs/blabla", http.StatusBadRequest},
+		{"When the job does not exist then it should return 404", "/jobs/b9f71a96-0d23-11ee-860e-ff55b106c448", http.StatusNotFound},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", test.endpoint, nil)
+			req.Header.Set("Authorization", "test")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, test.expectedCode, rr.Code)
+		})
+	}
+}
+
+func TestJobEndpointChecksTheClientRateLimitBeforeTheToken(t *testing.T) {
+	srv := newServer(configuration{
+		DefaultToken:    "test",
+		ClientRateLimit: rateLimit{Requests: 1, Interval: time.Minute},
+		Scripts:         []script{{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Inline: "echo inline"}},
+	})
+	j := srv.jobs.create(parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"))
+	call := func(token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", jobURL(j.ID), nil)
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		srv.router().ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, call("guess").Code)
+	assert.Equal(t, http.StatusTooManyRequests, call("another guess").Code)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// state holds everything that depends on the configuration. It is replaced as a whole on reload
+// so a request always sees a consistent configuration.
+type state struct {
+	config configuration
+	locks  map[string]*executionGate
+}
+
+type server struct {
+	state   atomic.Pointer[state]
+	jobs    *jobStore
+	limiter *rateLimiter
+	// history records every execution, it is nil when the history is disabled
+	history *historyStore
+	// stopping is cancelled on shutdown, so jobs still waiting for their turn give up
+	stopping context.Context
+	stop     context.CancelFunc
+	// background tracks the goroutines of async and coalesced jobs
+	background sync.WaitGroup
+}
+
+func newServer(c configuration) *server {
+	s := &server{jobs: newJobStore(c.JobRetention), limiter: newRateLimiter()}
+	s.stopping, s.stop = context.WithCancel(context.Background())
+	s.state.Store(&state{config: c, locks: getLocks(c, nil)})
+	return s
+}
+
+// untilStopping returns a context that is also cancelled when the server stops, so requests waiting
+// for their turn give up like the queued jobs
+func (s *server) untilStopping(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(s.stopping, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// waitForJobs blocks until the jobs started in the background are done or the context is done
+func (s *server) waitForJobs(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *server) current() (configuration, map[string]*executionGate) {
+	st := s.state.Load()
+	return st.config, st.locks
+}
+
+// reload swaps the configuration. Scripts whose concurrency limits didn't change keep their gate, so
+// executions started before the reload still exclude the ones started after it. A script whose limits
+// changed gets a fresh gate: its running and queued executions don't count against the new limits, and
+// requests coalesced before the reload don't join the ones coalesced after it.
+func (s *server) reload(c configuration) {
+	_, previousLocks := s.current()
+	s.state.Store(&state{config: c, locks: getLocks(c, previousLocks)})
+	s.jobs.setRetention(c.JobRetention)
+}
+
+// reloadFromFile reloads the configuration from configFile, keeping the current one if the file is not valid
+func (s *server) reloadFromFile(configFile string) {
+	c, err := getConfig(configFile)
+	if err != nil {
+		errorsTotal.Inc()
+		log.WithFields(log.Fields{"config": configFile}).Errorf("Keeping the current configuration, the new one is invalid: %v", err)
+		return
+	}
+	s.reload(c)
+	log.WithFields(log.Fields{"config": configFile, "scripts": len(c.Scripts)}).Info("Configuration reloaded")
+}
+
+// watchConfig reloads the configuration every time the file changes, checking it every interval
+func (s *server) watchConfig(configFile string, interval time.Duration) {
+	lastModified := getModificationTime(configFile)
+	for range time.Tick(interval) {
+		modified := getModificationTime(configFile)
+		if !modified.Equal(lastModified) {
+			lastModified = modified
+			s.reloadFromFile(configFile)
+		}
+	}
+}
+
+func getModificationTime(file string) time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (s *server) router() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hook", executionHandler(s))
+	mux.HandleFunc("/hooks/{hook}", executionHandler(s))
+	mux.HandleFunc("/jobs/{id}", jobHandler(s))
+	mux.HandleFunc("/executions", executionsHandler(s))
+	mux.HandleFunc("/executions/{id}", executionHistoryHandler(s))
+	mux.HandleFunc("/health", healthcheckHandler)
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+func getRouter(c configuration) *http.ServeMux {
+	return newServer(c).router()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadKeepsLocksOfRemainingScripts(t *testing.T) {
+	kept := script{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Inline: "echo kept"}
+	removed := script{ID: parseUUIDOrPanic("b9f71a96-0d23-11ee-860e-ff55b106c448"), Inline: "echo removed"}
+	srv := newServer(configuration{Scripts: []script{kept, removed}})
+	_, previousLocks := srv.current()
+
+	added := script{ID: parseUUIDOrPanic("5e5adb92-0d04-11ee-97cf-4b6c30e50f6a"), Inline: "echo added"}
+	srv.reload(configuration{Scripts: []script{kept, added}})
+
+	c, locks := srv.current()
+	assert.Equal(t, []script{kept, added}, c.Scripts)
+	assert.Same(t, previousLocks[kept.lockKey()], locks[kept.lockKey()])
+	assert.NotNil(t, locks[added.lockKey()])
+	assert.NotContains(t, locks, removed.lockKey())
+}
+
+func TestReloadFromFile(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(content string) {
+		require.NoError(t, os.WriteFile(configFile, []byte(content), 0600))
+	}
+	callHook := func(srv *server) int {
+		req, _ := http.NewRequest("GET", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", nil)
+		req.Header.Set("Authorization", "test")
+		rr := httptest.NewRecorder()
+		srv.router().ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	srv := newServer(configuration{DefaultToken: "test"})
+	assert.Equal(t, http.StatusBadRequest, callHook(srv))
+
+	writeConfig("default_token: test\nscripts:\n  - id: 47878e38-a700-11ee-bc6d-f3d25921fcde\n    inline: echo ok\n")
+	srv.reloadFromFile(configFile)
+	assert.Equal(t, http.StatusOK, callHook(srv))
+
+	writeConfig("default_token: test\nscripts:\n  - id: 47878e38-a700-11ee-bc6d-f3d25921fcde\n")
+	srv.reloadFromFile(configFile)
+	assert.Equal(t, http.StatusOK, callHook(srv), "an invalid configuration should not replace the current one")
+}
+
+func TestWatchConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte("default_token: test\n"), 0600))
+
+	srv := newServer(configuration{DefaultToken: "test"})
+	go srv.watchConfig(configFile, 10*time.Millisecond)
+
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, os.WriteFile(configFile, []byte("default_token: changed\n"), 0600))
+	require.NoError(t, os.Chtimes(configFile, time.Now(), time.Now().Add(time.Second)))
+
+	assert.Eventually(t, func() bool {
+		c, _ := srv.current()
+		return c.DefaultToken == "changed"
+	}, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// killDelay is how long scripts get to exit after SIGTERM before they are killed
+	killDelay = 5 * time.Second
+	// drainPollInterval is how often the running scripts are checked while waiting for them
+	drainPollInterval = 100 * time.Millisecond
+)
+
+var errShuttingDown = errors.New("shellhook is shutting down")
+
+// executionTracker keeps the process groups of the scripts that are running so they can be
+// waited for, or signaled, on shutdown
+type executionTracker struct {
+	mu     sync.Mutex
+	groups map[int]bool
+}
+
+var runningExecutions = &executionTracker{groups: make(map[int]bool)}
+
+func (t *executionTracker) add(pid int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.groups[pid] = true
+}
+
+func (t *executionTracker) done(pid int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.groups, pid)
+}
+
+func (t *executionTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.groups)
+}
+
+// signal sends sig to the process group of every running script
+func (t *executionTracker) signal(sig syscall.Signal) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for pid := range t.groups {
+		if err := syscall.Kill(-pid, sig); err != nil {
+			log.WithFields(log.Fields{"pid": pid, "signal": sig}).Errorf("Error signaling script: %v", err)
+		}
+	}
+}
+
+// wait blocks until no script is running or the context is done
+func (t *executionTracker) wait(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for t.count() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// gracefulShutdown stops accepting requests, cancels the jobs that are still waiting for their turn and waits
+// up to gracePeriod for the running scripts to finish. Scripts still running after that get SIGTERM and, if
+// they don't exit, SIGKILL. Once it returns no job is left to record its execution.
+func gracefulShutdown(httpServer *http.Server, srv *server, gracePeriod time.Duration) {
+	log.WithFields(log.Fields{"grace_period": gracePeriod.String(), "running": runningExecutions.count()}).Info("Shutting down, waiting for running scripts to finish")
+
+	srv.stop()
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Warningf("Not all requests finished in time: %v", err)
+	}
+	if srv.waitForJobs(ctx) == nil && runningExecutions.wait(ctx) == nil {
+		log.Info("Shutdown complete")
+		return
+	}
+
+	log.WithFields(log.Fields{"running": runningExecutions.count()}).Warning("Grace period expired, terminating running scripts")
+	runningExecutions.signal(syscall.SIGTERM)
+	killCtx, killCancel := context.WithTimeout(context.Background(), killDelay)
+	defer killCancel()
+	if err := runningExecutions.wait(killCtx); err != nil {
+		log.WithFields(log.Fields{"running": runningExecutions.count()}).Warning("Killing running scripts")
+		runningExecutions.signal(syscall.SIGKILL)
+	}
+	jobsCtx, jobsCancel := context.WithTimeout(context.Background(), killDelay)
+	defer jobsCancel()
+	if err := srv.waitForJobs(jobsCtx); err != nil {
+		log.Warningf("Not all jobs finished in time: %v", err)
+	}
+	log.Info("Shutdown complete")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startScript(t *testing.T, inline string) chan error {
+	finished := make(chan error, 1)
+	go func() {
+		_, err := executeScript(script{Inline: inline, Shell: "/bin/sh"}, nil, executionOptions{})
+		finished <- err
+	}()
+	require.Eventually(t, func() bool { return runningExecutions.count() == 1 }, 2*time.Second, 10*time.Millisecond)
+	return finished
+}
+
+func TestGracefulShutdownWaitsForRunningScripts(t *testing.T) {
+	finished := startScript(t, "sleep 0.3")
+
+	gracefulShutdown(&http.Server{}, newServer(configuration{}), 5*time.Second)
+
+	select {
+	case err := <-finished:
+		assert.NoError(t, err)
+	default:
+		t.Fatal("Expected the script to finish before the shutdown completed")
+	}
+}
+
+func TestGracefulShutdownTerminatesScriptsAfterGracePeriod(t *testing.T) {
+	finished := startScript(t, "sleep 10 & wait")
+
+	startTime := time.Now()
+	gracefulShutdown(&http.Server{}, newServer(configuration{}), 100*time.Millisecond)
+
+	assert.Less(t, time.Since(startTime), 2*time.Second)
+	select {
+	case err := <-finished:
+		assert.EqualError(t, err, "signal: terminated")
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the script to be terminated")
+	}
+	assert.Equal(t, 0, runningExecutions.count())
+}
+
+func TestGracefulShutdownWaitsForJobsAndCancelsQueuedOnes(t *testing.T) {
+	srv := newServer(configuration{DefaultToken: "test", Scripts: []script{
+		{ID: parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde"), Inline: "sleep 0.3", Shell: "/bin/sh", Async: true},
+	}})
+	startJob := func() uuid.UUID {
+		req, _ := http.NewRequest("GET", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde", nil)
+		req.Header.Set("Authorization", "test")
+		rr := httptest.NewRecorder()
+		srv.router().ServeHTTP(rr, req)
+		require.Equal(t, http.StatusAccepted, rr.Code)
+		return parseUUIDOrPanic(rr.Header().Get("Location")[len("/jobs/"):])
+	}
+	running := startJob()
+	require.Eventually(t, func() bool { return runningExecutions.count() == 1 }, 2*time.Second, 10*time.Millisecond)
+	queued := startJob()
+	waiting := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", "/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde&async=false", nil)
+		req.Header.Set("Authorization", "test")
+		rr := httptest.NewRecorder()
+		srv.router().ServeHTTP(rr, req)
+		waiting <- rr
+	}()
+	_, locks := srv.current()
+	require.Eventually(t, func() bool { return locks["script:47878e38-a700-11ee-bc6d-f3d25921fcde"].pending.Load() == 3 }, 2*time.Second, 10*time.Millisecond)
+
+	gracefulShutdown(&http.Server{}, srv, 5*time.Second)
+
+	j, _ := srv.jobs.get(running)
+	assert.Equal(t, jobSucceeded, j.Status, "the running job should finish before the shutdown completes")
+	j, _ = srv.jobs.get(queued)
+	assert.Equal(t, jobFailed, j.Status, "the queued job should be cancelled")
+	select {
+	case rr := <-waiting:
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code, "the queued request should give up")
+	case <-time.After(time.Second):
+		t.Fatal("Expected the queued request to give up on shutdown")
+	}
+	assert.Equal(t, 0, runningExecutions.count(), "no script should start after the shutdown")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	exitCodeTrailer = "X-Exit-Code"
+	// maxPendingLine bounds how much of an unterminated line is held before it is flushed anyway
+	maxPendingLine = 64 * 1024
+)
+
+// outputStream forwards the output of a running script to the client line by line,
+// either as chunked plain text or as Server-Sent Events.
+type outputStream struct {
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	sse    bool
+	err    error
+	stdout *lineWriter
+	stderr *lineWriter
+}
+
+type lineWriter struct {
+	stream  *outputStream
+	event   string
+	pending []byte
+}
+
+func newOutputStream(w http.ResponseWriter, sse bool) *outputStream {
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Trailer", exitCodeTrailer)
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	o := &outputStream{w: w, rc: http.NewResponseController(w), sse: sse}
+	o.stdout = &lineWriter{stream: o, event: "stdout"}
+	o.stderr = &lineWriter{stream: o, event: "stderr"}
+	o.flush()
+	return o
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.pending = append(l.pending, p...)
+	for {
+		i := bytes.IndexByte(l.pending, '\n')
+		if i < 0 {
+			break
+		}
+		l.stream.writeLine(l.event, l.pending[:i+1])
+		l.pending = l.pending[i+1:]
+	}
+	if len(l.pending) >= maxPendingLine {
+		l.stream.writeLine(l.event, l.pending)
+		l.pending = nil
+	}
+	return len(p), nil
+}
+
+func (l *lineWriter) flushPending() {
+	if len(l.pending) > 0 {
+		l.stream.writeLine(l.event, l.pending)
+		l.pending = nil
+	}
+}
+
+func (o *outputStream) writeLine(event string, line []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.sse {
+		o.write(fmt.Sprintf("event: %s\ndata: %s\n\n", event, strings.TrimRight(string(line), "\r\n")))
+	} else {
+		o.write(string(line))
+	}
+	o.flush()
+}
+
+// finish sends whatever is left of the output followed by the outcome of the execution
+func (o *outputStream) finish(result executionResult, err error) {
+	o.stdout.flushPending()
+	o.stderr.flushPending()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.sse {
+		exit := struct {
+			ExitCode int    `json:"exit_code"`
+			Error    string `json:"error,omitempty"`
+		}{ExitCode: result.ExitCode}
+		if err != nil {
+			exit.Error = err.Error()
+		}
+		data, _ := json.Marshal(exit)
+		o.write(fmt.Sprintf("event: exit\ndata: %s\n\n", data))
+	} else {
+		if err != nil {
+			o.write(fmt.Sprintf("%v\n", err))
+		}
+		o.w.Header().Set(exitCodeTrailer, strconv.Itoa(result.ExitCode))
+	}
+	o.flush()
+
+	if o.err != nil {
+		log.Errorf("error responding to request %v", o.err)
+	}
+}
+
+// write must be called with the lock held. Once the client is gone the rest of the output is discarded.
+func (o *outputStream) write(s string) {
+	if o.err != nil {
+		return
+	}
+	_, o.err = fmt.Fprint(o.w, s)
+}
+
+func (o *outputStream) flush() {
+	if o.err != nil {
+		return
+	}
+	o.err = o.rc.Flush()
+}
+
+func isStreaming(r *http.Request, scriptToRun script) bool {
+	if scriptToRun.HideOutput {
+		return false
+	}
+	if stream, err := strconv.ParseBool(r.URL.Query().Get("stream")); err == nil {
+		return stream
+	}
+	return scriptToRun.Stream || acceptsEventStream(r)
+}
+
+func acceptsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
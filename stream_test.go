@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamingExecution(t *testing.T) {
+	scriptID := parseUUIDOrPanic("47878e38-a700-11ee-bc6d-f3d25921fcde")
+	tests := []struct {
+		name             string
+		endpoint         string
+		accept           string
+		script           script
+		expectedType     string
+		expectedBody     string
+		expectedExitCode string
+	}{
+		{
+			"When streaming is requested in the query then the output should be sent as plain text",
+			"/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde&stream=true",
+			"",
+			script{ID: scriptID, Inline: "echo one; echo two"},
+			"text/plain; charset=utf-8",
+			"one\ntwo\n",
+			"0",
+		},
+		{
+			"When a streamed script fails then the error should be sent after the output",
+			"/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde",
+			"",
+			script{ID: scriptID, Inline: "echo -n partial; exit 3", Stream: true},
+			"text/plain; charset=utf-8",
+			"partialexit status 3\n",
+			"3",
+		},
+		{
+			"When the client accepts an event stream then the output should be sent as Server-Sent Events",
+			"/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde",
+			"text/event-stream",
+			script{ID: scriptID, Inline: "echo one; sleep 0.1; echo two >&2", ReturnStderr: true},
+			"text/event-stream",
+			"event: stdout\ndata: one\n\nevent: stderr\ndata: two\n\nevent: exit\ndata: {\"exit_code\":0}\n\n",
+			"",
+		},
+		{
+			"When return_stderr is not set then stderr should not be streamed",
+			"/hook?script=47878e38-a700-11ee-bc6d-f3d25921fcde&stream=true",
+			"",
+			script{ID: scriptID, Inline: "echo one; echo two >&2"},
+			"text/plain; charset=utf-8",
+			"one\n",
+			"0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := getRouter(configuration{DefaultToken: "test", Scripts: []script{test.script}})
+			req, _ := http.NewRequest("GET", test.endpoint, nil)
+			req.Header.Set("Authorization", "test")
+			req.Header.Set("Accept", test.accept)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.True(t, rr.Flushed)
+			assert.Equal(t, test.expectedType, rr.Header().Get("Content-Type"))
+			assert.Equal(t, test.expectedBody, rr.Body.String())
+			assert.Equal(t, test.expectedExitCode, rr.Result().Trailer.Get(exitCodeTrailer))
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// validateConfig loads the configuration like the server does and then looks for the mistakes that would
+// otherwise only show up when a hook is called
+func validateConfig(configFile string) []error {
+	c, err := getConfig(configFile)
+	if err != nil {
+		return []error{err}
+	}
+
+	problems := checkUnknownKeys(configFile)
+	if !c.hasDefaultCredentials() {
+		for _, s := range c.Scripts {
+			if s.usesDefaultCredentials() {
+				problems = append(problems, fmt.Errorf("script %s: has no token and there is no default_token", s.ID))
+			}
+		}
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	for _, s := range c.Scripts {
+		if seen[s.ID] {
+			problems = append(problems, fmt.Errorf("script %s: the ID is duplicated", s.ID))
+		}
+		seen[s.ID] = true
+
+		if s.Path != "" {
+			if err := checkExecutable(s.Path); err != nil {
+				problems = append(problems, fmt.Errorf("script %s: %v", s.ID, err))
+			}
+		}
+		if s.User != "" {
+			if _, err := user.Lookup(s.User); err != nil {
+				problems = append(problems, fmt.Errorf("script %s: %v", s.ID, err))
+			}
+		}
+		if s.Shell != "" {
+			if _, err := exec.LookPath(s.Shell); err != nil {
+				problems = append(problems, fmt.Errorf("script %s: shell %v", s.ID, err))
+			}
+		}
+	}
+	return problems
+}
+
+// checkUnknownKeys decodes the file again rejecting keys that don't match any setting, which are
+// usually typos that make a setting silently take its default value
+func checkUnknownKeys(configFile string) []error {
+	yamlFile, err := os.ReadFile(configFile)
+	if err != nil {
+		return []error{err}
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(yamlFile))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&configuration{}); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+func checkExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	if info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("%s is not executable", path)
+	}
+	return nil
+}
+
+func (c configuration) hasDefaultCredentials() bool {
+	return c.DefaultToken != "" || c.DefaultTokenHash != "" || len(c.DefaultTokens) > 0
+}
+
+func (s script) usesDefaultCredentials() bool {
+	return (s.Auth.Mode == "" || s.Auth.Mode == authModeToken) && s.Token == "" && s.TokenHash == "" && len(s.Tokens) == 0
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfig(t *testing.T) {
+	directory := t.TempDir()
+	notExecutable := filepath.Join(directory, "not-executable.sh")
+	require.NoError(t, os.WriteFile(notExecutable, []byte("echo ok"), 0644))
+
+	tests := []struct {
+		name             string
+		config           string
+		expectedProblems []string
+	}{
+		{
+			"When the configuration is correct then there should be no problems",
+			"default_token: test\nscripts:\n  - id: 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a\n    path: ./scripts/success.sh\n    shell: /bin/sh\n",
+			nil,
+		},
+		{
+			"When the configuration can't be loaded then it should be the only problem",
+			"default_token_hash: secret\nscripts:\n  - id: 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a\n    path: ./scripts/missing.sh\n",
+			[]string{"invalid default_token_hash: use either default_token or a bcrypt, argon2id or sha256 hash"},
+		},
+		{
+			"When a key is unknown then it should be reported",
+			"default_token: test\nscripts:\n  - id: 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a\n    inline: echo ok\n    concurent: true\n",
+			[]string{"yaml: unmarshal errors:\n  line 5: field concurent not found in type main.script"},
+		},
+		{
+			"When a script ID is duplicated then it should be reported",
+			"default_token: test\nscripts:\n  - id: 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a\n    inline: echo ok\n  - id: 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a\n    inline: echo ko\n",
+			[]string{"script 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a: the ID is duplicated"},
+		},
+		{
+			"When the path, user or shell of a script don't exist then they should be reported",
+			"default_token: test\nscripts:\n  - id: 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a\n    path: ./scripts/missing.sh\n    user: nonexistent-shellhook-user\n    shell: /bin/nonexistent-shell\n",
+			[]string{
+				"script 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a: stat ./scripts/missing.sh: no such file or directory",
+				"script 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a: user: unknown user nonexistent-shellhook-user",
+				"script 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a: shell exec: \"/bin/nonexistent-shell\": stat /bin/nonexistent-shell: no such file or directory",
+			},
+		},
+		{
+			"When the path of a script is not executable then it should be reported",
+			"default_token: test\nscripts:\n  - id: 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a\n    path: " + notExecutable + "\n",
+			[]string{"script 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a: " + notExecutable + " is not executable"},
+		},
+		{
+			"When a script relies on the default token and there is none then it should be reported",
+			"scripts:\n  - id: 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a\n    inline: echo ok\n  - id: 47878e38-a700-11ee-bc6d-f3d25921fcde\n    inline: echo ok\n    token: test\n",
+			[]string{"script 5e5adb92-0d04-11ee-97cf-4b6c30e50f6a: has no token and there is no default_token"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configFile := filepath.Join(directory, "config.yaml")
+			require.NoError(t, os.WriteFile(configFile, []byte(test.config), 0600))
+
+			var problems []string
+			for _, problem := range validateConfig(configFile) {
+				problems = append(problems, problem.Error())
+			}
+			assert.Equal(t, test.expectedProblems, problems)
+		})
+	}
+}